@@ -0,0 +1,18 @@
+module github.com/akito0107/dicon
+
+go 1.22.0
+
+require (
+	github.com/andreyvit/diff v0.0.0-20170406064948-c7f18ee00883
+	github.com/pkg/errors v0.9.1
+	github.com/urfave/cli v1.22.17
+	golang.org/x/tools v0.30.0
+)
+
+require (
+	github.com/cpuguy83/go-md2man/v2 v2.0.7 // indirect
+	github.com/russross/blackfriday/v2 v2.1.0 // indirect
+	github.com/sergi/go-diff v1.4.0 // indirect
+	golang.org/x/mod v0.23.0 // indirect
+	golang.org/x/sync v0.11.0 // indirect
+)
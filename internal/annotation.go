@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"strings"
+)
+
+const annotationPrefix = "+DICON:"
+
+// annotation is a "+DICON:<key>[=<value>] [args...]" comment line, e.g.
+// "+DICON:scope=transient" or "+DICON:inject replica=ReplicaDB".
+type annotation struct {
+	Key   string
+	Value string
+	Args  []string
+}
+
+func parseAnnotation(c comment) (annotation, bool) {
+	s := string(c)
+	if !strings.HasPrefix(s, annotationPrefix) {
+		return annotation{}, false
+	}
+	fields := strings.Fields(strings.TrimPrefix(s, annotationPrefix))
+	if len(fields) == 0 {
+		return annotation{}, false
+	}
+	a := annotation{Args: fields[1:]}
+	if i := strings.Index(fields[0], "="); i >= 0 {
+		a.Key, a.Value = fields[0][:i], fields[0][i+1:]
+	} else {
+		a.Key = fields[0]
+	}
+	return a, true
+}
+
+func (cs comments) annotations(key string) []annotation {
+	var res []annotation
+	for _, c := range cs {
+		if a, ok := parseAnnotation(c); ok && a.Key == key {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+func (cs comments) annotation(key string) (annotation, bool) {
+	as := cs.annotations(key)
+	if len(as) == 0 {
+		return annotation{}, false
+	}
+	return as[0], true
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAnnotation(t *testing.T) {
+	cases := []struct {
+		in  comment
+		ok  bool
+		out annotation
+	}{
+		{"+DICON:scope=transient", true, annotation{Key: "scope", Value: "transient", Args: []string{}}},
+		{"+DICON:inject replica=ReplicaDB", true, annotation{Key: "inject", Args: []string{"replica=ReplicaDB"}}},
+		{"+DICON:decorate  order=1  target=UserService", true, annotation{Key: "decorate", Args: []string{"order=1", "target=UserService"}}},
+		{"+DICON", false, annotation{}},
+		{"+DICON:", false, annotation{}},
+		{"NewUserService creates a UserService", false, annotation{}},
+	}
+	for _, c := range cases {
+		got, ok := parseAnnotation(c.in)
+		if ok != c.ok {
+			t.Errorf("%q: unexpected ok %v", c.in, ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(got, c.out) {
+			t.Errorf("%q: expected %+v, but got %+v", c.in, c.out, got)
+		}
+	}
+}
+
+func TestCommentsAnnotation(t *testing.T) {
+	cs := comments{"UserService serves users.", "+DICON:scope=request", "+DICON:scope=transient"}
+	a, ok := cs.annotation("scope")
+	if !ok || a.Value != "request" {
+		t.Errorf("first scope annotation must be request but %+v", a)
+	}
+	if _, ok := cs.annotation("inject"); ok {
+		t.Errorf("inject annotation must not be found")
+	}
+	if n := len(cs.annotations("scope")); n != 2 {
+		t.Errorf("must be 2 scope annotations but %d", n)
+	}
+}
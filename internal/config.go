@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"fmt"
+	"go/types"
+	"reflect"
+	"strings"
+)
+
+const configTag = "dicon"
+
+// isConfig reports whether the container method f is annotated with
+// "+DICON:config". Its value is passed to NewDIContainer instead of being
+// built by a constructor, and its fields are injected into the constructor
+// arguments of the same name.
+func (f FuncType) isConfig() bool {
+	_, ok := f.Comments.annotation("config")
+	return ok
+}
+
+// configStruct returns the struct returned by the config method f.
+func configStruct(f FuncType) (*types.Struct, error) {
+	t := f.ReturnTypes[0].Type()
+	if t == nil {
+		return nil, fmt.Errorf("config '%s' must return a struct, but the type is unknown", f.Name)
+	}
+	if p, ok := t.Underlying().(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok {
+		return nil, fmt.Errorf("config '%s' must return a struct or a pointer to struct, but %s", f.Name, t)
+	}
+	return st, nil
+}
+
+// configField returns the field of st which is injected into p, matching
+// the `dicon:"name"` tag or else the field name case insensitively.
+func configField(st *types.Struct, pkgPath string, p ParameterType) (string, bool) {
+	t := p.Type()
+	if p.Name == "" || p.Name == "_" || t == nil {
+		return "", false
+	}
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		if field.Embedded() || (!field.Exported() && field.Pkg().Path() != pkgPath) {
+			continue
+		}
+		name := reflect.StructTag(st.Tag(i)).Get(configTag)
+		if name == "-" {
+			continue
+		}
+		if name != p.Name && (name != "" || !strings.EqualFold(field.Name(), p.Name)) {
+			continue
+		}
+		if types.AssignableTo(field.Type(), t) {
+			return field.Name(), true
+		}
+	}
+	return "", false
+}
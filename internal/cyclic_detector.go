@@ -62,6 +62,7 @@ func (cd *cyclicDetector) walk(name string, state *walkState) error {
 		return err
 	}
 	if _, ok := cd.visited[name]; ok {
+		state.leave()
 		return nil
 	}
 	for _, dependency := range cd.dependencies[name] {
@@ -77,11 +78,30 @@ func (cd *cyclicDetector) walk(name string, state *walkState) error {
 func DetectCyclicDependency(funcs []FuncType) error {
 	dependencies := make(map[string][]string, len(funcs))
 	for _, fn := range funcs {
-		name := fn.ReturnTypes[0].SimpleName()
+		name := fn.ReturnTypes[0].key()
+		if fn.Name != "" {
+			name = fn.Name
+		}
 
 		deps := make([]string, 0, len(fn.ArgumentTypes))
-		for _, dep := range fn.ArgumentTypes {
-			deps = append(deps, dep.SimpleName())
+		for i, dep := range fn.ArgumentTypes {
+			if i < len(fn.Dependencies) {
+				// a thunk is only called after the construction, so it
+				// does not take part in a cycle.
+				if fn.Dependencies[i].Lazy {
+					continue
+				}
+				deps = appendUnique(deps, fn.Dependencies[i].methods()...)
+			} else {
+				deps = appendUnique(deps, dep.key())
+			}
+		}
+		for _, d := range fn.Decorators {
+			for _, dep := range d.Dependencies {
+				if !dep.Lazy {
+					deps = appendUnique(deps, dep.methods()...)
+				}
+			}
 		}
 		dependencies[name] = deps
 	}
@@ -91,3 +111,14 @@ func DetectCyclicDependency(funcs []FuncType) error {
 	}
 	return cd.detect()
 }
+
+// appendUnique appends the names not in deps yet, e.g. a config passed
+// twice or the members shared by two groups.
+func appendUnique(deps []string, names ...string) []string {
+	for _, n := range names {
+		if !contains(n, deps) {
+			deps = append(deps, n)
+		}
+	}
+	return deps
+}
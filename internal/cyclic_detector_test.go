@@ -2,6 +2,8 @@ package internal
 
 import (
 	"go/ast"
+	"go/token"
+	"go/types"
 	"testing"
 )
 
@@ -71,3 +73,91 @@ func TestDetectCyclicDependency(t *testing.T) {
 		}
 	}
 }
+
+func TestDetectCyclicDependencySameNameInOtherPackages(t *testing.T) {
+	a := types.NewPackage("a", "a")
+	b := types.NewPackage("b", "b")
+	repo := func(pkg *types.Package) types.Type {
+		return types.NewNamed(types.NewTypeName(token.NoPos, pkg, "Repository", nil), types.NewInterfaceType(nil, nil), nil)
+	}
+	aRepo := ParameterType{src: ast.NewIdent("Repository"), typ: repo(a)}
+	bRepo := ParameterType{src: ast.NewIdent("Repository"), typ: repo(b)}
+
+	funcs := []FuncType{
+		{
+			ArgumentTypes: []ParameterType{bRepo},
+			ReturnTypes:   []ParameterType{aRepo},
+		},
+		{
+			ArgumentTypes: []ParameterType{},
+			ReturnTypes:   []ParameterType{bRepo},
+		},
+	}
+	if err := DetectCyclicDependency(funcs); err != nil {
+		t.Errorf("a.Repository and b.Repository must be distinguished, but got: %v", err)
+	}
+}
+
+func TestDetectCyclicDependencyLazy(t *testing.T) {
+	funcs := []FuncType{
+		{
+			Name:          "A",
+			ArgumentTypes: []ParameterType{{src: ast.NewIdent("B")}},
+			ReturnTypes:   []ParameterType{{src: ast.NewIdent("A")}},
+			Dependencies:  []Dependency{{Method: "B", Lazy: true}},
+		},
+		{
+			Name:          "B",
+			ArgumentTypes: []ParameterType{{src: ast.NewIdent("A")}},
+			ReturnTypes:   []ParameterType{{src: ast.NewIdent("B")}},
+			Dependencies:  []Dependency{{Method: "A"}},
+		},
+	}
+	if err := DetectCyclicDependency(funcs); err != nil {
+		t.Errorf("a lazy dependency must not be part of a cycle, but got: %v", err)
+	}
+	funcs[0].Dependencies[0].Lazy = false
+	if err := DetectCyclicDependency(funcs); err == nil {
+		t.Error("cyclic dependency must be detected")
+	}
+}
+
+func TestDetectCyclicDependencyDuplicateEdges(t *testing.T) {
+	config := FuncType{
+		Name:        "Config",
+		ReturnTypes: []ParameterType{{src: ast.NewIdent("Config")}},
+		Config:      true,
+	}
+	funcs := []FuncType{
+		config,
+		{
+			Name: "A",
+			ArgumentTypes: []ParameterType{
+				{src: ast.NewIdent("string")},
+				{src: ast.NewIdent("int")},
+				{src: ast.NewIdent("bool")},
+			},
+			ReturnTypes: []ParameterType{{src: ast.NewIdent("A")}},
+			Dependencies: []Dependency{
+				{Method: "Config", Field: "Name"},
+				{Method: "Config", Field: "Port"},
+				{Method: "Config", Field: "Debug"},
+			},
+		},
+		{
+			Name:          "B",
+			ArgumentTypes: []ParameterType{{src: ast.NewIdent("[]Handler")}, {src: ast.NewIdent("[]Handler")}},
+			ReturnTypes:   []ParameterType{{src: ast.NewIdent("B")}},
+			Dependencies: []Dependency{
+				{Group: "handlers", Members: []string{"A", "Config"}},
+				{Group: "admin", Members: []string{"A"}},
+			},
+		},
+	}
+	// the walk order depends on the map iteration.
+	for i := 0; i < 20; i++ {
+		if err := DetectCyclicDependency(funcs); err != nil {
+			t.Fatalf("duplicate edges must not be reported as a cycle, but got: %v", err)
+		}
+	}
+}
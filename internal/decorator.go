@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"fmt"
+	"go/ast"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// decoratorFuncs builds the decorators of the container methods from fun,
+// annotated with "+DICON:decorate [<Method>...] [order=<n>]". A decorator
+// takes the component as its first argument and returns T or (T, error);
+// without method names, it decorates every method returning its first
+// argument type.
+func decoratorFuncs(pkg *packages.Package, fun *ast.FuncDecl, cs comments, targets []FuncType) ([]FuncType, error) {
+	name := fun.Name.Name
+	a, _ := cs.annotation("decorate")
+	methods := decoratedMethods(a)
+	if len(methods) > 0 && !containsTarget(methods, targets) {
+		// the decorator targets the methods of another container.
+		return nil, nil
+	}
+	order := 0
+	for _, arg := range a.Args {
+		if !strings.HasPrefix(arg, "order=") {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(arg, "order="))
+		if err != nil {
+			return nil, fmt.Errorf("%s: malformed decorate '%s': order must be an integer", name, arg)
+		}
+		order = n
+	}
+
+	args := fieldTypes(pkg.Name, pkg.TypesInfo, fun.Type.Params)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s must take the decorated component as its first argument", name)
+	}
+	returns := fieldTypes(pkg.Name, pkg.TypesInfo, fun.Type.Results)
+	if len(returns) != len(fun.Type.Results.List) || len(returns) > 2 {
+		return nil, fmt.Errorf("%s must return T or (T, error)", name)
+	}
+	if err := checkConstructorResults(pkg.Name, name, returns); err != nil {
+		return nil, err
+	}
+
+	var res []FuncType
+	for _, t := range targets {
+		if len(t.ReturnTypes) == 0 {
+			continue
+		}
+		if len(methods) > 0 && !contains(t.Name, methods) {
+			continue
+		}
+		if !args[0].Identical(t.ReturnTypes[0]) {
+			if len(methods) == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("%s decorates '%s', but takes %s instead of %s",
+				name, t.Name, args[0].ConvertName(pkg.Name), t.ReturnTypes[0].ConvertName(pkg.Name))
+		}
+		rets, err := provideAs(name, returns, t)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, FuncType{
+			ArgumentTypes: args,
+			ReturnTypes:   rets,
+			Name:          t.Name,
+			FuncName:      name,
+			PackageName:   pkg.Name,
+			PackagePath:   pkg.PkgPath,
+			Comments:      cs,
+			Decorator:     true,
+			Order:         order,
+			Variadic:      isVariadic(fun.Type),
+		})
+	}
+	return res, nil
+}
+
+// decoratedMethods returns the container methods named by the decorate
+// annotation a.
+func decoratedMethods(a annotation) []string {
+	var res []string
+	for _, arg := range a.Args {
+		if !strings.HasPrefix(arg, "order=") {
+			res = append(res, arg)
+		}
+	}
+	return res
+}
+
+func containsTarget(methods []string, targets []FuncType) bool {
+	for _, m := range methods {
+		if indexOfFunc(m, targets) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckDecorators reports the decorators of the loaded packages naming a
+// method declared by none of the containers its, since each container
+// leaves out the decorators of the others.
+func CheckDecorators(loaded []*packages.Package, its []InterfaceType) error {
+	var methods []FuncType
+	for i := range its {
+		methods = append(methods, its[i].components()...)
+	}
+	for _, pkg := range loaded {
+		for _, f := range pkg.Syntax {
+			if isGeneratedByDicon(f) {
+				continue
+			}
+			for _, decl := range f.Decls {
+				fun, ok := decl.(*ast.FuncDecl)
+				if !ok || fun.Recv != nil {
+					continue
+				}
+				a, ok := findComments(fun.Doc).annotation("decorate")
+				if !ok {
+					continue
+				}
+				for _, m := range decoratedMethods(a) {
+					if indexOfFunc(m, methods) < 0 {
+						return fmt.Errorf("%s decorates unknown container method '%s'", fun.Name.Name, m)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func splitDecorators(funcs []FuncType) ([]FuncType, []FuncType) {
+	var constructors, decorators []FuncType
+	for _, f := range funcs {
+		if f.Decorator {
+			decorators = append(decorators, f)
+		} else {
+			constructors = append(constructors, f)
+		}
+	}
+	return constructors, decorators
+}
+
+// decorate resolves the dependencies of the decorators, following the
+// decorated component, and attaches them to the constructors in order.
+func (idx *providerIndex) decorate(it *InterfaceType, funcs, decorators []FuncType) error {
+	for _, d := range decorators {
+		i := indexOfFunc(d.Name, funcs)
+		if i < 0 {
+			continue
+		}
+		if funcs[i].Config {
+			return fmt.Errorf("%s: config '%s' can not be decorated", d.FuncName, d.Name)
+		}
+		deps, err := idx.resolveArguments(it, d, d.ArgumentTypes[1:])
+		if err != nil {
+			return err
+		}
+		d.Dependencies = deps
+		funcs[i].Decorators = append(funcs[i].Decorators, d)
+	}
+	for i := range funcs {
+		ds := funcs[i].Decorators
+		sort.SliceStable(ds, func(a, b int) bool { return ds[a].Order < ds[b].Order })
+	}
+	return nil
+}
+
+// allDependencies returns the dependencies of f and of its decorators.
+func (f FuncType) allDependencies() []Dependency {
+	res := f.Dependencies
+	for _, d := range f.Decorators {
+		res = append(res[:len(res):len(res)], d.Dependencies...)
+	}
+	return res
+}
+
+// appendDecorators applies the decorators of f to the instance, and returns
+// the variable holding the decorated component.
+func (g *Generator) appendDecorators(f FuncType, returnType, zero string, takesContext map[string]bool) string {
+	g.Printf("var decorated %s = instance\n", returnType)
+	for k, d := range f.Decorators {
+		args := g.appendArguments(fmt.Sprintf("dec%ddep", k), d.ArgumentTypes[1:], d.Dependencies, zero, takesContext)
+		if d.Variadic && len(args) > 0 {
+			args[len(args)-1] += "..."
+		}
+		args = append([]string{"decorated"}, args...)
+		call := fmt.Sprintf("%s%s(%s)", g.relativePackageName(d.PackagePath, d.PackageName), d.FuncName, strings.Join(args, ", "))
+		if len(d.ReturnTypes) == 1 {
+			g.Printf("decorated = %s\n", call)
+			continue
+		}
+		g.Printf("dec%d, err := %s\n", k, call)
+		g.Printf("if err != nil {\n")
+		g.Printf("return %s, errors.Wrap(err, \"decoration %s of %s failed at DICON\")\n", zero, d.FuncName, f.Name)
+		g.Printf("}\n")
+		g.Printf("decorated = dec%d\n", k)
+	}
+	return "decorated"
+}
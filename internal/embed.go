@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// flattenEmbeds adds the methods of the interfaces embedded in it, which may
+// be declared in other packages. An embedded interface is parsed from its
+// declaration, so that the annotations of its methods are kept, while the
+// methods declared by it itself take precedence.
+func flattenEmbeds(pkg *packages.Package, it *InterfaceType, seen map[string]struct{}) error {
+	if len(it.embeds) == 0 || pkg.TypesInfo == nil {
+		return nil
+	}
+	all := map[string]*packages.Package{}
+	packages.Visit([]*packages.Package{pkg}, func(p *packages.Package) bool {
+		all[p.PkgPath] = p
+		return true
+	}, nil)
+
+	for _, expr := range it.embeds {
+		t := pkg.TypesInfo.TypeOf(expr)
+		n, ok := t.(*types.Named)
+		if !ok || !types.IsInterface(t) {
+			return fmt.Errorf("%s: embedded %s must be a named interface", it.Name, types.ExprString(expr))
+		}
+		obj := n.Obj()
+		if obj.Pkg() == nil {
+			// a predeclared interface such as error has no container method.
+			return fmt.Errorf("%s: embedded %s must be an interface declared in a package", it.Name, obj.Name())
+		}
+		key := obj.Pkg().Path() + "." + obj.Name()
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		embedded, err := embeddedInterface(all[obj.Pkg().Path()], obj, seen)
+		if err != nil {
+			return fmt.Errorf("%s: %v", it.Name, err)
+		}
+		it.embedded = append(append(it.embedded, obj), embedded.embedded...)
+		for _, f := range embedded.Funcs {
+			if indexOfFunc(f.Name, it.Funcs) >= 0 {
+				continue
+			}
+			if f.PackagePath == "" {
+				f.PackageName, f.PackagePath = obj.Pkg().Name(), obj.Pkg().Path()
+			}
+			it.Funcs = append(it.Funcs, f)
+		}
+	}
+	return nil
+}
+
+func embeddedInterface(pkg *packages.Package, obj *types.TypeName, seen map[string]struct{}) (*InterfaceType, error) {
+	if pkg != nil {
+		for _, f := range pkg.Syntax {
+			spec := findTypeSpec(f, obj.Name())
+			if spec == nil {
+				continue
+			}
+			it, ok := findInterface(pkg.Name, pkg.TypesInfo, []ast.Spec{spec})
+			if !ok {
+				break
+			}
+			if err := findProviders(pkg, f, it); err != nil {
+				return nil, err
+			}
+			if err := flattenEmbeds(pkg, it, seen); err != nil {
+				return nil, err
+			}
+			return it, nil
+		}
+	}
+	return interfaceFromType(obj), nil
+}
+
+// interfaceFromType builds the methods of an interface which is known from
+// export data only, e.g. of the standard library.
+func interfaceFromType(obj *types.TypeName) *InterfaceType {
+	iface := obj.Type().Underlying().(*types.Interface)
+	it := &InterfaceType{Name: obj.Name()}
+	for i := 0; i < iface.NumMethods(); i++ {
+		m := iface.Method(i)
+		sig := m.Type().(*types.Signature)
+		f := FuncType{Name: m.Name()}
+		for j := 0; j < sig.Params().Len(); j++ {
+			v := sig.Params().At(j)
+			f.ArgumentTypes = append(f.ArgumentTypes, ParameterType{DeclaredPackageName: obj.Pkg().Name(), Name: v.Name(), typ: v.Type()})
+		}
+		for j := 0; j < sig.Results().Len(); j++ {
+			f.ReturnTypes = append(f.ReturnTypes, ParameterType{DeclaredPackageName: obj.Pkg().Name(), typ: sig.Results().At(j).Type()})
+		}
+		it.Funcs = append(it.Funcs, f)
+	}
+	return it
+}
+
+func findTypeSpec(f *ast.File, name string) *ast.TypeSpec {
+	for _, decl := range f.Decls {
+		g, ok := decl.(*ast.GenDecl)
+		if !ok || g.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range g.Specs {
+			if t := spec.(*ast.TypeSpec); t.Name.Name == name {
+				return t
+			}
+		}
+	}
+	return nil
+}
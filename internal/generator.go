@@ -3,17 +3,28 @@ package internal
 import (
 	"bytes"
 	"fmt"
-	"go/ast"
+	"go/token"
+	"go/types"
 	"io"
 	"log"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
+	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/imports"
 )
 
 type Generator struct {
 	buf         bytes.Buffer
 	PackageName string
+	PackagePath string
+	imports     *importSet
+	structName  string
+	scoped      bool
+	closable    bool
 }
 
 func NewGenerator() *Generator {
@@ -26,25 +37,184 @@ func (g *Generator) Printf(format string, args ...interface{}) {
 
 func (g *Generator) Generate(it *InterfaceType, fs []FuncType) error {
 	g.PackageName = it.PackageName
-	g.appendHeader(it)
+	g.PackagePath = it.PackagePath
+	g.structName = containerStructName(it)
+	g.scoped = it.hasScopeMethod()
+	for _, f := range fs {
+		g.scoped = g.scoped || f.Scope == requestScope
+	}
+	g.closable = it.hasCloseMethod()
+	for _, f := range fs {
+		g.closable = g.closable || f.hasCleanup() ||
+			(!f.Config && f.Scope != transientScope && closerOf(f.ReturnTypes[0]) != noCloser)
+	}
+
+	// the body is rendered first, so that the header can import every
+	// package the body refers to.
 	g.appendStructDefs(it)
-	g.appendMethod(fs)
+	g.appendMethod(sortByDeclaration(it, fs))
+	body := g.takeBuffer()
+
+	g.appendHeader(it)
+	g.buf.Write(body)
 	return nil
 }
 
+// sortByDeclaration orders fs as the methods of it are declared, so that
+// the output does not depend on the order the constructors were found in.
+func sortByDeclaration(it *InterfaceType, fs []FuncType) []FuncType {
+	res := append([]FuncType(nil), fs...)
+	sort.SliceStable(res, func(i, j int) bool {
+		return indexOfFunc(res[i].Name, it.Funcs) < indexOfFunc(res[j].Name, it.Funcs)
+	})
+	return res
+}
+
+// GenerateContainer runs the pipeline of "dicon generate" for the container
+// it: the constructors found from the loaded packages are resolved, checked
+// for cycles and rendered.
+func GenerateContainer(loaded []*packages.Package, it *InterfaceType) (*Generator, error) {
+	// NewScope and Close are implemented by the container, so they have
+	// no constructor.
+	targets, fallbacks := ConstructorPackages(loaded, it)
+	funcs, err := findConstructorsIn(targets, it.components())
+	if err != nil {
+		return nil, err
+	}
+	// the packages declaring the returned types, e.g. strings for
+	// *strings.Replacer, only provide the constructors missing from the
+	// targeted packages.
+	constructors, _ := splitDecorators(funcs)
+	var missing []FuncType
+	for _, m := range it.components() {
+		if indexOfFunc(m.Name, constructors) < 0 {
+			missing = append(missing, m)
+		}
+	}
+	fs, err := findConstructorsIn(fallbacks, missing)
+	if err != nil {
+		return nil, err
+	}
+	funcs = append(funcs, fs...)
+
+	funcs, err = ResolveDependencies(it, funcs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", it.Name, err)
+	}
+	if err := DetectCyclicDependency(funcs); err != nil {
+		return nil, fmt.Errorf("%s: %v", it.Name, err)
+	}
+
+	g := NewGenerator()
+	if err := g.Generate(it, funcs); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func findConstructorsIn(pkgs []*packages.Package, targets []FuncType) ([]FuncType, error) {
+	var res []FuncType
+	for _, pkg := range pkgs {
+		fs, err := NewPackageParser(pkg).FindConstructors(targets)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, fs...)
+	}
+	return res, nil
+}
+
 func (g *Generator) GenerateMock(it *InterfaceType, targets []InterfaceType) error {
 	if g.PackageName == "" {
 		g.PackageName = it.PackageName
 	}
-	g.appendHeader(it)
-	g.appendImports(targets)
-
 	for _, i := range targets {
 		g.appendMockStruct(&i)
 	}
+	body := g.takeBuffer()
+
+	g.appendHeader(it)
+	g.appendImports(targets)
+	g.buf.Write(body)
 	return nil
 }
 
+// containerStructName returns the name of the struct implementing it,
+// which defaults to the lower cased interface name, e.g. dicontainer for
+// DIContainer, or containerImpl for an unexported container interface.
+func containerStructName(it *InterfaceType) string {
+	if it.StructName != "" {
+		return it.StructName
+	}
+	if name := strings.ToLower(it.Name); name != it.Name {
+		return name
+	}
+	return it.Name + "Impl"
+}
+
+// containerConstructor returns the name of the function creating the
+// container, which defaults to New<Interface>.
+func containerConstructor(it *InterfaceType) string {
+	if it.Constructor != "" {
+		return it.Constructor
+	}
+	return "New" + it.Name
+}
+
+func (g *Generator) takeBuffer() []byte {
+	b := append([]byte(nil), g.buf.Bytes()...)
+	g.buf.Reset()
+	return b
+}
+
+func (g *Generator) importSet() *importSet {
+	if g.imports == nil {
+		g.imports = newImportSet()
+		g.imports.reserve("log", "log")
+		g.imports.reserve("sync", "sync")
+		g.imports.reserve("github.com/pkg/errors", "errors")
+		g.imports.reserve("fmt", "fmt")
+		g.imports.reserve("errors", "stderrors")
+	}
+	return g.imports
+}
+
+func (g *Generator) contextPkg() string {
+	return g.importSet().name("context", "context")
+}
+
+// typeName renders p as seen from the generated package, registering the
+// packages it refers to.
+func (g *Generator) typeName(p ParameterType) string {
+	if t := p.Type(); t != nil {
+		return types.TypeString(t, g.qualifier)
+	}
+	return p.ConvertName(g.PackageName)
+}
+
+// paramTypeName renders p as a parameter of a signature, ...T when p is
+// variadic.
+func (g *Generator) paramTypeName(p ParameterType) string {
+	if s, ok := p.Type().(*types.Slice); ok && p.variadic() {
+		return "..." + types.TypeString(s.Elem(), g.qualifier)
+	}
+	return g.typeName(p)
+}
+
+func (g *Generator) qualifier(pkg *types.Package) string {
+	if g.isCurrentPackage(pkg.Path(), pkg.Name()) {
+		return ""
+	}
+	return g.importSet().name(pkg.Path(), pkg.Name())
+}
+
+func (g *Generator) isCurrentPackage(path, name string) bool {
+	if path != "" && g.PackagePath != "" {
+		return path == g.PackagePath
+	}
+	return name == g.PackageName
+}
+
 func (g *Generator) Out(w io.Writer, filename string) error {
 	src := g.buf.Bytes()
 	for i := 0; i < 2; i++ {
@@ -68,10 +238,21 @@ func (g *Generator) appendHeader(it *InterfaceType) {
 	g.Printf("\n")
 	g.Printf("import (\n")
 	g.Printf("\"log\"\n")
+	g.Printf("\"sync\"\n")
 	g.Printf("\"github.com/pkg/errors\"\n")
+	if g.closable {
+		g.Printf("stderrors \"errors\"\n")
+	}
+	if g.imports != nil {
+		for _, spec := range g.imports.specs() {
+			g.Printf("%s\n", spec)
+		}
+	}
 	g.Printf(")\n")
 }
 
+// appendImports copies the imports of the files declaring targets, for the
+// types which were rendered from syntax only.
 func (g *Generator) appendImports(targets []InterfaceType) {
 	g.Printf("import (\n")
 	defer g.Printf(")\n")
@@ -79,6 +260,10 @@ func (g *Generator) appendImports(targets []InterfaceType) {
 	imported := make(map[string]struct{})
 	for _, target := range targets {
 		for _, dep := range target.DependPackages {
+			path, err := strconv.Unquote(dep.Path)
+			if err != nil || g.importSet().has(path) {
+				continue
+			}
 			if _, ok := imported[dep.Path]; !ok {
 				g.Printf("%s %s\n", dep.Name, dep.Path)
 				imported[dep.Path] = struct{}{}
@@ -87,55 +272,345 @@ func (g *Generator) appendImports(targets []InterfaceType) {
 	}
 }
 
+// containerFields are the fields and the unexported methods of the generated
+// container, which can not be the names of container methods.
+var containerFields = []string{"parent", "opts", "components", "closers", "onClose"}
+
+// appendStructDefs declares the container with one field per component.
+// Every component is built while holding its own lock, so that concurrent
+// callers share a single instance.
+//
+// A container created by NewScope refers to its parent, which holds the
+// singletons, and keeps the request scoped components on its own.
 func (g *Generator) appendStructDefs(it *InterfaceType) {
-	g.Printf("type dicontainer struct {\n")
-	g.Printf("store map[string]interface{}\n")
+	g.Printf("type %s struct {\n", g.structName)
+	if g.scoped {
+		g.Printf("parent *%s\n", g.structName)
+		g.Printf("opts []%s\n", optionType(it))
+	}
+	// the components have a namespace of their own, so that a method such
+	// as Parent() does not collide with the fields of the container.
+	var stored []FuncType
+	for _, f := range it.components() {
+		if f.isConfig() || f.Scope != transientScope {
+			stored = append(stored, f)
+		}
+	}
+	if len(stored) > 0 {
+		g.Printf("components struct {\n")
+		for _, f := range stored {
+			if f.isConfig() {
+				g.Printf("%s %s\n", fieldName(f.Name), g.typeName(f.ReturnTypes[0]))
+				continue
+			}
+			g.Printf("%s struct {\n", fieldName(f.Name))
+			g.Printf("sync.Mutex\n")
+			g.Printf("done bool\n")
+			g.Printf("instance %s\n", g.typeName(f.ReturnTypes[0]))
+			g.Printf("}\n")
+		}
+		g.Printf("}\n")
+	}
+	if g.closable {
+		g.Printf("closers struct {\n")
+		g.Printf("sync.Mutex\n")
+		g.Printf("fns []func(%s.Context) error\n", g.contextPkg())
+		g.Printf("}\n")
+	}
+	g.Printf("}\n")
+	g.Printf("\n")
+	var params, fields, configs []string
+	for _, f := range it.components() {
+		if f.isConfig() {
+			params = append(params, fmt.Sprintf("%s %s", configParam(f.Name), g.typeName(f.ReturnTypes[0])))
+			configs = append(configs, f.Name)
+		}
+	}
+	g.appendOptions(it)
+	params = append(params, "opts ..."+optionType(it))
+	if g.scoped {
+		fields = append(fields, "opts: opts")
+	}
+	g.Printf("func %s(%s) %s {\n", containerConstructor(it), strings.Join(params, ", "), it.Name)
+	g.Printf("d := &%s{%s}\n", g.structName, strings.Join(fields, ", "))
+	for _, c := range configs {
+		g.Printf("d.components.%s = %s\n", fieldName(c), configParam(c))
+	}
+	g.appendApplyOptions("d", "opts")
+	g.Printf("return d\n")
+	g.Printf("}\n")
+	g.Printf("\n")
+	if g.scoped {
+		// a scope applies the options again, pre-seeding its request
+		// scoped components.
+		scopeType := it.Name
+		if m, ok := it.scopeMethod(); ok {
+			scopeType = g.typeName(m.ReturnTypes[0])
+		}
+		g.Printf("func (d *%s) NewScope() %s {\n", g.structName, scopeType)
+		g.Printf("s := &%s{parent: d, opts: d.opts}\n", g.structName)
+		for _, c := range configs {
+			g.Printf("s.components.%s = d.components.%s\n", fieldName(c), fieldName(c))
+		}
+		g.appendApplyOptions("s", "d.opts")
+		g.Printf("return s\n")
+		g.Printf("}\n")
+		g.Printf("\n")
+	}
+	if g.closable {
+		g.appendCloseMethod()
+	}
+}
+
+// appendCloseMethod tears down the components in the reverse order of their
+// creation, so that every component is closed before its dependencies.
+// A scope closes only the request scoped components it has built.
+func (g *Generator) appendCloseMethod() {
+	ctx := g.contextPkg()
+	g.Printf("func (d *%s) onClose(fn func(%s.Context) error) {\n", g.structName, ctx)
+	g.Printf("d.closers.Lock()\n")
+	g.Printf("defer d.closers.Unlock()\n")
+	g.Printf("d.closers.fns = append(d.closers.fns, fn)\n")
+	g.Printf("}\n")
+	g.Printf("\n")
+	g.Printf("func (d *%s) Close(ctx %s.Context) error {\n", g.structName, ctx)
+	g.Printf("d.closers.Lock()\n")
+	g.Printf("fns := d.closers.fns\n")
+	g.Printf("d.closers.fns = nil\n")
+	g.Printf("d.closers.Unlock()\n")
+	g.Printf("var errs []error\n")
+	g.Printf("for i := len(fns) - 1; i >= 0; i-- {\n")
+	g.Printf("if err := fns[i](ctx); err != nil {\n")
+	g.Printf("errs = append(errs, err)\n")
 	g.Printf("}\n")
-	g.Printf("func NewDIContainer() %s {\n", it.Name)
-	g.Printf("return &dicontainer{\n")
-	g.Printf("store: map[string]interface{}{},\n")
 	g.Printf("}\n")
+	g.Printf("return stderrors.Join(errs...)\n")
 	g.Printf("}\n")
 	g.Printf("\n")
 }
 
 func (g *Generator) appendMethod(funcs []FuncType) {
+	takesContext := make(map[string]bool, len(funcs))
+	for _, f := range funcs {
+		takesContext[f.Name] = f.TakesContext
+	}
 	for _, f := range funcs {
-		g.Printf("func (d *dicontainer) %s()", f.Name)
-		if len(f.ReturnTypes) != 2 {
-			log.Fatalf("Must be (instance, error) signature but %v", f.ReturnTypes)
+		args := ""
+		if f.TakesContext {
+			args = "ctx"
+			g.Printf("func (d *%s) %s(ctx %s.Context)", g.structName, f.Name, g.contextPkg())
+		} else {
+			g.Printf("func (d *%s) %s()", g.structName, f.Name)
+		}
+		if len(f.ReturnTypes) == 0 || len(f.ReturnTypes) > 3 {
+			log.Fatalf("Must be instance, (instance, error) or (instance, func(), error) signature but %v", f.ReturnTypes)
 		}
 
-		returnType := f.ReturnTypes[0]
-		g.Printf("(%s, error) {\n", returnType.ConvertName(g.PackageName))
+		returnType := g.typeName(f.ReturnTypes[0])
+		g.Printf("(%s, error) {\n", returnType)
+		zero := g.zeroValue(f.ReturnTypes[0])
 
-		g.Printf("if i, ok := d.store[\"%s\"]; ok {\n", f.Name)
-		g.Printf("instance, ok := i.(%s)\n", returnType.ConvertName(g.PackageName))
-		g.Printf("if !ok {\n")
-		g.Printf("return nil, fmt.Errorf(\"invalid instance is cached %%v\", instance)\n")
-		g.Printf("}\n")
-		g.Printf("return instance, nil\n")
-		g.Printf("}\n")
+		if f.Config {
+			// a nil config would panic when its fields are injected.
+			if _, ok := f.ReturnTypes[0].Type().(*types.Pointer); ok {
+				g.Printf("if d.components.%s == nil {\n", fieldName(f.Name))
+				g.Printf("return nil, errors.New(\"config %s is nil: pass it to the container constructor\")\n", f.Name)
+				g.Printf("}\n")
+			}
+			g.Printf("return d.components.%s, nil\n", fieldName(f.Name))
+			g.Printf("}\n")
+			continue
+		}
 
-		dep := make([]string, 0, len(f.ArgumentTypes))
-		for i, a := range f.ArgumentTypes {
-			g.Printf("dep%d, err := d.%s()\n", i, a.SimpleName())
-			g.Printf("if err != nil {\n")
-			g.Printf("return nil, errors.Wrap(err, \"resolve %s failed at DICON\")\n", a.SimpleName())
+		cached := f.Scope != transientScope
+		if g.scoped && f.Scope != transientScope && f.Scope != requestScope {
+			g.Printf("if d.parent != nil {\n")
+			g.Printf("return d.parent.%s(%s)\n", f.Name, args)
+			g.Printf("}\n")
+		}
+		// the root container would keep a request scoped component for its
+		// whole lifetime.
+		if f.Scope == requestScope {
+			g.Printf("if d.parent == nil {\n")
+			g.Printf("return %s, errors.New(\"%s is request scoped: resolve it from NewScope()\")\n", zero, f.Name)
+			g.Printf("}\n")
+		}
+		if cached {
+			g.Printf("c := &d.components.%s\n", fieldName(f.Name))
+			g.Printf("c.Lock()\n")
+			g.Printf("defer c.Unlock()\n")
+			g.Printf("if c.done {\n")
+			g.Printf("return c.instance, nil\n")
 			g.Printf("}\n")
-			dep = append(dep, fmt.Sprintf("dep%d", i))
 		}
 
-		g.Printf("instance, err := %sNew%s(%s)\n", g.relativePackageName(f.PackageName), f.Name, strings.Join(dep, ", "))
-		g.Printf("if err != nil {\n")
-		g.Printf("return nil, errors.Wrap(err, \"creation %s failed at DICON\")\n", f.Name)
-		g.Printf("}\n")
-		g.Printf("d.store[\"%s\"] = instance\n", f.Name)
-		g.Printf("return instance, nil\n")
+		// a method called without a context resolves the context aware
+		// dependencies with a background context.
+		if !f.TakesContext && needsContext(f, takesContext) {
+			g.Printf("ctx := %s.Background()\n", g.contextPkg())
+		}
+
+		dep := g.appendArguments("dep", f.ArgumentTypes, f.Dependencies, zero, takesContext)
+		if f.Variadic && len(dep) > 0 {
+			dep[len(dep)-1] += "..."
+		}
+		constructor := g.relativePackageName(f.PackagePath, f.PackageName) + f.constructorName()
+		switch {
+		case f.Impl != nil:
+			g.Printf("instance := %s\n", g.implLiteral(f, dep))
+		case len(f.ReturnTypes) == 1:
+			g.Printf("instance := %s(%s)\n", constructor, strings.Join(dep, ", "))
+		case len(f.ReturnTypes) == 2:
+			g.Printf("instance, err := %s(%s)\n", constructor, strings.Join(dep, ", "))
+		case len(f.ReturnTypes) == 3:
+			g.Printf("instance, cleanup, err := %s(%s)\n", constructor, strings.Join(dep, ", "))
+		}
+		if len(f.ReturnTypes) > 1 {
+			g.Printf("if err != nil {\n")
+			g.Printf("return %s, errors.Wrap(err, \"creation %s failed at DICON\")\n", zero, f.Name)
+			g.Printf("}\n")
+		}
+		// the cleanup function owns the teardown of the instance.
+		if f.hasCleanup() {
+			g.Printf("d.onClose(func(%s.Context) error {\n", g.contextPkg())
+			g.Printf("cleanup()\n")
+			g.Printf("return nil\n")
+			g.Printf("})\n")
+		}
+		if cached {
+			if call := closeCall(closerOf(f.ReturnTypes[0]), "instance", "ctx"); call != "" && !f.hasCleanup() {
+				g.Printf("d.onClose(func(ctx %s.Context) error {\n", g.contextPkg())
+				g.Printf("return errors.Wrap(%s, \"close %s failed at DICON\")\n", call, f.Name)
+				g.Printf("})\n")
+			}
+		}
+		result := "instance"
+		if len(f.Decorators) > 0 {
+			result = g.appendDecorators(f, returnType, zero, takesContext)
+		}
+		if cached {
+			g.Printf("c.instance, c.done = %s, true\n", result)
+		}
+		g.Printf("return %s, nil\n", result)
 		g.Printf("}\n")
 	}
 }
 
+// appendArguments resolves the dependencies deps of the arguments args into
+// variables named after prefix, and returns the expressions to pass.
+func (g *Generator) appendArguments(prefix string, args []ParameterType, deps []Dependency, zero string, takesContext map[string]bool) []string {
+	res := make([]string, 0, len(args))
+	for i := range args {
+		d := Dependency{Method: args[i].SimpleName()}
+		if i < len(deps) {
+			d = deps[i]
+		}
+		v := fmt.Sprintf("%s%d", prefix, i)
+		switch {
+		case d.Context:
+			res = append(res, "ctx")
+		case d.Optional:
+			res = append(res, g.zeroValue(args[i]))
+		case d.Lazy:
+			g.appendLazy(v, args[i], d, takesContext)
+			res = append(res, v)
+		case d.Group != "":
+			g.appendGroup(v, args[i], d, zero, takesContext)
+			res = append(res, v)
+		default:
+			g.appendResolve(v, d.Method, zero, takesContext)
+			if d.Field != "" {
+				v += "." + d.Field
+			}
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func (g *Generator) appendResolve(v, method, zero string, takesContext map[string]bool) {
+	if takesContext[method] {
+		g.Printf("%s, err := d.%s(ctx)\n", v, method)
+	} else {
+		g.Printf("%s, err := d.%s()\n", v, method)
+	}
+	g.Printf("if err != nil {\n")
+	g.Printf("return %s, errors.Wrap(err, \"resolve %s failed at DICON\")\n", zero, method)
+	g.Printf("}\n")
+}
+
+// appendGroup resolves the members of the group d and collects them into v,
+// a slice or a map keyed by the member method names.
+func (g *Generator) appendGroup(v string, p ParameterType, d Dependency, zero string, takesContext map[string]bool) {
+	values := make([]string, 0, len(d.Members))
+	for j, m := range d.Members {
+		mv := fmt.Sprintf("%sm%d", v, j)
+		g.appendResolve(mv, m, zero, takesContext)
+		if d.Keyed {
+			mv = fmt.Sprintf("%q: %s", m, mv)
+		}
+		values = append(values, mv)
+	}
+	g.Printf("%s := %s{%s}\n", v, g.typeName(p), strings.Join(values, ", "))
+}
+
+// implLiteral renders the struct literal of f.Impl, setting each field to
+// the dependency resolved for it.
+func (g *Generator) implLiteral(f FuncType, deps []string) string {
+	fields := make([]string, 0, len(deps))
+	for i, d := range deps {
+		fields = append(fields, fmt.Sprintf("%s: %s", f.ArgumentTypes[i].Name, d))
+	}
+	t := f.Impl.Type()
+	if p, ok := t.(*types.Pointer); ok {
+		return fmt.Sprintf("&%s{%s}", types.TypeString(p.Elem(), g.qualifier), strings.Join(fields, ", "))
+	}
+	return fmt.Sprintf("%s{%s}", types.TypeString(t, g.qualifier), strings.Join(fields, ", "))
+}
+
+// zeroValue renders the value returned along with an error. Types known
+// from syntax only are assumed to be nillable.
+func (g *Generator) zeroValue(p ParameterType) string {
+	t := p.Type()
+	if t == nil {
+		return "nil"
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return "false"
+		case u.Info()&types.IsString != 0:
+			return `""`
+		case u.Info()&types.IsNumeric != 0:
+			return "0"
+		}
+		return "nil"
+	case *types.Struct, *types.Array:
+		return g.typeName(p) + "{}"
+	case *types.TypeParam:
+		return "*new(" + g.typeName(p) + ")"
+	}
+	return "nil"
+}
+
+func needsContext(f FuncType, takesContext map[string]bool) bool {
+	for _, d := range f.allDependencies() {
+		if d.Context {
+			return true
+		}
+		if d.Lazy {
+			continue
+		}
+		for _, m := range d.methods() {
+			if takesContext[m] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (g *Generator) appendMockStruct(it *InterfaceType) {
 	g.Printf("type %sMock struct {\n", it.Name)
 	args := map[string][]string{}
@@ -144,13 +619,13 @@ func (g *Generator) appendMockStruct(it *InterfaceType) {
 	for _, f := range it.Funcs {
 		var ags []string
 		for i, a := range f.ArgumentTypes {
-			ags = append(ags, fmt.Sprintf("a%d %s", i, a.ConvertName(g.PackageName)))
+			ags = append(ags, fmt.Sprintf("a%d %s", i, g.paramTypeName(a)))
 		}
 		args[f.Name] = ags
 
 		var rets []string
 		for _, r := range f.ReturnTypes {
-			rets = append(rets, r.ConvertName(g.PackageName))
+			rets = append(rets, g.typeName(r))
 		}
 		returns[f.Name] = rets
 		g.Printf("%sMock func(%s)", f.Name, strings.Join(ags, ","))
@@ -182,7 +657,7 @@ func (g *Generator) appendMockStruct(it *InterfaceType) {
 		g.Printf(" {\n")
 		var a []string
 		for i, at := range f.ArgumentTypes {
-			if _, ok := at.src.(*ast.Ellipsis); ok {
+			if at.variadic() {
 				a = append(a, fmt.Sprintf("a%d...", i))
 			} else {
 				a = append(a, fmt.Sprintf("a%d", i))
@@ -196,9 +671,36 @@ func (g *Generator) appendMockStruct(it *InterfaceType) {
 	}
 }
 
-func (g *Generator) relativePackageName(packageName string) string {
-	if g.PackageName == packageName {
+func (g *Generator) relativePackageName(packagePath, packageName string) string {
+	if g.isCurrentPackage(packagePath, packageName) {
 		return ""
 	}
-	return packageName + "."
+	if packagePath == "" {
+		return packageName + "."
+	}
+	return g.importSet().name(packagePath, packageName) + "."
+}
+
+// fieldName is the name of the container field holding the component
+// provided by method. A name which is a Go keyword, such as map for Map(),
+// is suffixed with _.
+func fieldName(method string) string {
+	r, n := utf8.DecodeRuneInString(method)
+	name := string(unicode.ToLower(r)) + method[n:]
+	if token.IsKeyword(name) {
+		return name + "_"
+	}
+	return name
+}
+
+// configParam names the parameter of the container constructor taking the
+// config method, which must not shadow the variables of the constructor.
+// Like fieldName, it escapes the Go keywords, e.g. type_ for Type().
+func configParam(method string) string {
+	name := fieldName(method)
+	switch name {
+	case "d", "opt", "opts":
+		return name + "Config"
+	}
+	return name
 }
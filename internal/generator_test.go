@@ -2,12 +2,20 @@ package internal
 
 import (
 	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"go/ast"
 	"go/parser"
+	"go/token"
+	"go/types"
 
 	"github.com/andreyvit/diff"
+	"golang.org/x/tools/go/packages"
 )
 
 func TestGenerator_appendHeader(t *testing.T) {
@@ -17,6 +25,7 @@ func TestGenerator_appendHeader(t *testing.T) {
 
 	import (
 		"log"
+		"sync"
 		"github.com/pkg/errors"
 	)
 `))
@@ -34,18 +43,21 @@ func TestGenerator_appendHeader(t *testing.T) {
 
 func TestGenerator_appendStructDef(t *testing.T) {
 	ex := pretty(t, []byte(`type dicontainer struct {
-		store map[string]interface{}
 	}
 
-	func NewDIContainer() DIContainer {
-		return &dicontainer{
-			store: map[string]interface{}{},
+	type DIContainerOption func(*dicontainer)
+
+	func NewDIContainer(opts ...DIContainerOption) DIContainer {
+		d := &dicontainer{}
+		for _, opt := range opts {
+			opt(d)
 		}
+		return d
 	}
 
 `))
 
-	g := &Generator{}
+	g := &Generator{structName: "dicontainer"}
 	it := &InterfaceType{
 		Name: "DIContainer",
 	}
@@ -58,12 +70,11 @@ func TestGenerator_appendStructDef(t *testing.T) {
 
 func TestGenerator_appendMethods(t *testing.T) {
 	ex := pretty(t, []byte(`func (d *dicontainer) SampleComponent() (SampleComponent, error) {
-	if i, ok := d.store["SampleComponent"]; ok {
-		instance, ok := i.(SampleComponent)
-		if !ok {
-			return nil, fmt.Errorf("invalid instance is cached %v", instance)
-		}
-		return instance, nil
+	c := &d.components.sampleComponent
+	c.Lock()
+	defer c.Unlock()
+	if c.done {
+		return c.instance, nil
 	}
 	dep0, err := d.Dependency()
 	if err != nil {
@@ -73,7 +84,7 @@ func TestGenerator_appendMethods(t *testing.T) {
 	if err != nil {
 		return nil, errors.Wrap(err, "creation SampleComponent failed at DICON")
 	}
-	d.store["SampleComponent"] = instance
+	c.instance, c.done = instance, true
 	return instance, nil
 }
 `))
@@ -98,7 +109,7 @@ func TestGenerator_appendMethods(t *testing.T) {
 	}
 
 	it := &InterfaceType{Funcs: []FuncType{f1}}
-	g := &Generator{PackageName: "test"}
+	g := &Generator{PackageName: "test", structName: "dicontainer"}
 	g.appendMethod(it.Funcs)
 
 	act := pretty(t, g.buf.Bytes())
@@ -109,12 +120,11 @@ func TestGenerator_appendMethods(t *testing.T) {
 
 func TestGenerator_appendMethodsMultipleDependencies(t *testing.T) {
 	ex := pretty(t, []byte(`func (d *dicontainer) SampleComponent() (SampleComponent, error) {
-	if i, ok := d.store["SampleComponent"]; ok {
-		instance, ok := i.(SampleComponent)
-		if !ok {
-			return nil, fmt.Errorf("invalid instance is cached %v", instance)
-		}
-		return instance, nil
+	c := &d.components.sampleComponent
+	c.Lock()
+	defer c.Unlock()
+	if c.done {
+		return c.instance, nil
 	}
 	dep0, err := d.Dependency1()
 	if err != nil {
@@ -128,7 +138,7 @@ func TestGenerator_appendMethodsMultipleDependencies(t *testing.T) {
 	if err != nil {
 		return nil, errors.Wrap(err, "creation SampleComponent failed at DICON")
 	}
-	d.store["SampleComponent"] = instance
+	c.instance, c.done = instance, true
 	return instance, nil
 }
 `))
@@ -156,7 +166,7 @@ func TestGenerator_appendMethodsMultipleDependencies(t *testing.T) {
 	}
 
 	it := &InterfaceType{Funcs: []FuncType{f1}}
-	g := &Generator{PackageName: "test"}
+	g := &Generator{PackageName: "test", structName: "dicontainer"}
 	g.appendMethod(it.Funcs)
 
 	act := pretty(t, g.buf.Bytes())
@@ -165,6 +175,78 @@ func TestGenerator_appendMethodsMultipleDependencies(t *testing.T) {
 	}
 }
 
+func TestGenerator_appendMethodsWithoutError(t *testing.T) {
+	ex := pretty(t, []byte(`func (d *dicontainer) SampleComponent() (SampleComponent, error) {
+	c := &d.components.sampleComponent
+	c.Lock()
+	defer c.Unlock()
+	if c.done {
+		return c.instance, nil
+	}
+	dep0, err := d.Dependency()
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve Dependency failed at DICON")
+	}
+	instance := NewSampleComponent(dep0)
+	c.instance, c.done = instance, true
+	return instance, nil
+}
+`))
+	f1 := FuncType{
+		Name:          "SampleComponent",
+		ArgumentTypes: []ParameterType{{DeclaredPackageName: "test", src: createAst(t, "Dependency")}},
+		ReturnTypes:   []ParameterType{{DeclaredPackageName: "test", src: createAst(t, "SampleComponent")}},
+		PackageName:   "test",
+	}
+
+	g := &Generator{PackageName: "test", structName: "dicontainer"}
+	g.appendMethod([]FuncType{f1})
+
+	act := pretty(t, g.buf.Bytes())
+	if !bytes.Equal(act, ex) {
+		t.Errorf("Not Matched: \n%v", diff.LineDiff(string(ex), string(act)))
+	}
+}
+
+func TestGenerator_appendMethodsWithCleanup(t *testing.T) {
+	ex := pretty(t, []byte(`func (d *dicontainer) SampleComponent() (SampleComponent, error) {
+	c := &d.components.sampleComponent
+	c.Lock()
+	defer c.Unlock()
+	if c.done {
+		return c.instance, nil
+	}
+	instance, cleanup, err := NewSampleComponent()
+	if err != nil {
+		return nil, errors.Wrap(err, "creation SampleComponent failed at DICON")
+	}
+	d.onClose(func(context.Context) error {
+		cleanup()
+		return nil
+	})
+	c.instance, c.done = instance, true
+	return instance, nil
+}
+`))
+	f1 := FuncType{
+		Name: "SampleComponent",
+		ReturnTypes: []ParameterType{
+			{DeclaredPackageName: "test", src: createAst(t, "SampleComponent")},
+			{DeclaredPackageName: "test", src: createAst(t, "func()")},
+			{DeclaredPackageName: "test", src: createAst(t, "error")},
+		},
+		PackageName: "test",
+	}
+
+	g := &Generator{PackageName: "test", structName: "dicontainer"}
+	g.appendMethod([]FuncType{f1})
+
+	act := pretty(t, g.buf.Bytes())
+	if !bytes.Equal(act, ex) {
+		t.Errorf("Not Matched: \n%v", diff.LineDiff(string(ex), string(act)))
+	}
+}
+
 func TestGenerate(t *testing.T) {
 	ex := pretty(t, []byte(`// Code generated by "dicon"; DO NOT EDIT.
 
@@ -172,26 +254,42 @@ func TestGenerate(t *testing.T) {
 
 	import (
 		"log"
+		"sync"
 		"github.com/pkg/errors"
 	)
 
 	type dicontainer struct {
-		store map[string]interface{}
+		components struct {
+			sampleComponent struct {
+				sync.Mutex
+				done     bool
+				instance SampleComponent
+			}
+		}
+	}
+
+	type DIContainerOption func(*dicontainer)
+
+	func WithSampleComponent(instance SampleComponent) DIContainerOption {
+		return func(d *dicontainer) {
+			d.components.sampleComponent.instance, d.components.sampleComponent.done = instance, true
+		}
 	}
 
-	func NewDIContainer() DIContainer {
-		return &dicontainer{
-			store: map[string]interface{}{},
+	func NewDIContainer(opts ...DIContainerOption) DIContainer {
+		d := &dicontainer{}
+		for _, opt := range opts {
+			opt(d)
 		}
+		return d
 	}
 
 	func (d *dicontainer) SampleComponent() (SampleComponent, error) {
-		if i, ok := d.store["SampleComponent"]; ok {
-			instance, ok := i.(SampleComponent)
-			if !ok {
-				return nil, fmt.Errorf("invalid instance is cached %v", instance)
-			}
-			return instance, nil
+		c := &d.components.sampleComponent
+		c.Lock()
+		defer c.Unlock()
+		if c.done {
+			return c.instance, nil
 		}
 		dep0, err := d.Dependency1()
 		if err != nil {
@@ -205,7 +303,7 @@ func TestGenerate(t *testing.T) {
 		if err != nil {
 			return nil, errors.Wrap(err, "creation SampleComponent failed at DICON")
 		}
-		d.store["SampleComponent"] = instance
+		c.instance, c.done = instance, true
 		return instance, nil
 	}`))
 
@@ -249,6 +347,33 @@ func TestGenerate(t *testing.T) {
 	}
 }
 
+func TestGenerateDeclarationOrder(t *testing.T) {
+	returns := func(name string) []ParameterType {
+		return []ParameterType{
+			{DeclaredPackageName: "test", src: createAst(t, name)},
+			{DeclaredPackageName: "test", src: createAst(t, "error")},
+		}
+	}
+	it := &InterfaceType{
+		Name:        "DIContainer",
+		PackageName: "test",
+		Funcs: []FuncType{
+			{Name: "Dependency1", ReturnTypes: returns("Dependency1"), PackageName: "test"},
+			{Name: "Dependency2", ReturnTypes: returns("Dependency2"), PackageName: "test"},
+		},
+	}
+
+	g1 := &Generator{PackageName: "test"}
+	g1.Generate(it, it.Funcs)
+	g2 := &Generator{PackageName: "test"}
+	g2.Generate(it, []FuncType{it.Funcs[1], it.Funcs[0]})
+
+	ex, act := pretty(t, g1.buf.Bytes()), pretty(t, g2.buf.Bytes())
+	if !bytes.Equal(act, ex) {
+		t.Errorf("methods must follow the interface order: \n%v", diff.LineDiff(string(ex), string(act)))
+	}
+}
+
 func TestGenerateSubPackage(t *testing.T) {
 	ex := fixImports(t, []byte(`// Code generated by "dicon"; DO NOT EDIT.
 
@@ -256,28 +381,44 @@ func TestGenerateSubPackage(t *testing.T) {
 
 	import (
 		"log"
+		"sync"
 
 		"github.com/akito0107/dicon/sample"
 		"github.com/pkg/errors"
 	)
 
 	type dicontainer struct {
-		store map[string]interface{}
+		components struct {
+			sampleComponent struct {
+				sync.Mutex
+				done     bool
+				instance sample.SampleComponent
+			}
+		}
+	}
+
+	type DIContainerOption func(*dicontainer)
+
+	func WithSampleComponent(instance sample.SampleComponent) DIContainerOption {
+		return func(d *dicontainer) {
+			d.components.sampleComponent.instance, d.components.sampleComponent.done = instance, true
+		}
 	}
 
-	func NewDIContainer() DIContainer {
-		return &dicontainer{
-			store: map[string]interface{}{},
+	func NewDIContainer(opts ...DIContainerOption) DIContainer {
+		d := &dicontainer{}
+		for _, opt := range opts {
+			opt(d)
 		}
+		return d
 	}
 
 	func (d *dicontainer) SampleComponent() (sample.SampleComponent, error) {
-		if i, ok := d.store["SampleComponent"]; ok {
-			instance, ok := i.(sample.SampleComponent)
-			if !ok {
-				return nil, fmt.Errorf("invalid instance is cached %v", instance)
-			}
-			return instance, nil
+		c := &d.components.sampleComponent
+		c.Lock()
+		defer c.Unlock()
+		if c.done {
+			return c.instance, nil
 		}
 		dep0, err := d.Dependency1()
 		if err != nil {
@@ -291,7 +432,7 @@ func TestGenerateSubPackage(t *testing.T) {
 		if err != nil {
 			return nil, errors.Wrap(err, "creation SampleComponent failed at DICON")
 		}
-		d.store["SampleComponent"] = instance
+		c.instance, c.done = instance, true
 		return instance, nil
 	}`))
 
@@ -557,6 +698,48 @@ func TestAppendMockStructVariadicArguments(t *testing.T) {
 	}
 }
 
+var TEST_MOCK_VARIADIC = `
+package di
+
+import (
+	"context"
+	"time"
+)
+
+type Svc interface {
+	Exec(c context.Context, d time.Duration, args ...string) error
+}
+`
+
+func TestAppendMockStructTypedVariadicArguments(t *testing.T) {
+	ex := pretty(t, []byte(`type SvcMock struct {
+		ExecMock func(a0 context.Context, a1 time.Duration, a2 ...string) error
+	}
+
+	func NewSvcMock() *SvcMock {
+		return &SvcMock{}
+	}
+
+	func (mk *SvcMock) Exec(a0 context.Context, a1 time.Duration, a2 ...string) error {
+		return mk.ExecMock(a0, a1, a2...)
+	}
+`))
+
+	pkg := parseTestPackage(t, "di", TEST_MOCK_VARIADIC)
+	its, err := NewPackageParser(pkg).FindDependencyInterfaces([]string{"Svc"})
+	if err != nil || len(its) != 1 {
+		t.Fatalf("Svc not found: %v", err)
+	}
+
+	g := NewGenerator()
+	g.PackageName = "mock"
+	g.appendMockStruct(&its[0])
+	act := pretty(t, g.buf.Bytes())
+	if !bytes.Equal(act, ex) {
+		t.Errorf("Not Matched: \n%v", diff.LineDiff(string(ex), string(act)))
+	}
+}
+
 func createAst(t *testing.T, expr string) ast.Expr {
 	t.Helper()
 	ex, err := parser.ParseExpr(expr)
@@ -566,3 +749,1554 @@ func createAst(t *testing.T, expr string) ast.Expr {
 	}
 	return ex
 }
+
+func TestGenerateImportCollision(t *testing.T) {
+	named := func(path, name string) types.Type {
+		pkg := types.NewPackage(path, "repository")
+		return types.NewNamed(types.NewTypeName(token.NoPos, pkg, name, nil), types.NewInterfaceType(nil, nil), nil)
+	}
+	user := ParameterType{src: createAst(t, "repository.User"), typ: named("example.com/a/repository", "User")}
+	item := ParameterType{src: createAst(t, "repository.Item"), typ: named("example.com/b/repository", "Item")}
+	e1 := ParameterType{src: createAst(t, "error"), typ: types.Universe.Lookup("error").Type()}
+
+	f1 := FuncType{
+		Name:        "User",
+		ReturnTypes: []ParameterType{user, e1},
+		PackageName: "repository",
+		PackagePath: "example.com/a/repository",
+	}
+	f2 := FuncType{
+		Name:          "Item",
+		ArgumentTypes: []ParameterType{user},
+		Dependencies:  []Dependency{{Method: "User"}},
+		ReturnTypes:   []ParameterType{item, e1},
+		PackageName:   "repository",
+		PackagePath:   "example.com/b/repository",
+	}
+	it := &InterfaceType{
+		Name:        "DIContainer",
+		PackageName: "di",
+		PackagePath: "example.com/di",
+		Funcs:       []FuncType{f1, f2},
+	}
+
+	g := NewGenerator()
+	if err := g.Generate(it, it.Funcs); err != nil {
+		t.Fatal(err)
+	}
+	act := string(pretty(t, g.buf.Bytes()))
+
+	for _, ex := range []string{
+		"\t\"example.com/a/repository\"\n",
+		"\trepository2 \"example.com/b/repository\"\n",
+		"func (d *dicontainer) Item() (repository2.Item, error) {",
+		"instance, err := repository.NewUser()",
+		"instance, err := repository2.NewItem(dep0)",
+	} {
+		if !strings.Contains(act, ex) {
+			t.Errorf("%q not found in:\n%s", ex, act)
+		}
+	}
+}
+
+func TestGenerateScopes(t *testing.T) {
+	comp := func(name, scope string) FuncType {
+		return FuncType{
+			Name:        name,
+			Scope:       scope,
+			ReturnTypes: []ParameterType{{src: createAst(t, name)}, {src: createAst(t, "error")}},
+			PackageName: "di",
+		}
+	}
+	it := &InterfaceType{
+		Name:        "DIContainer",
+		PackageName: "di",
+		Funcs:       []FuncType{comp("Logger", ""), comp("Session", "request"), comp("Handler", "transient")},
+	}
+	fs := []FuncType{comp("Logger", "singleton"), comp("Session", "request"), comp("Handler", "transient")}
+
+	g := NewGenerator()
+	if err := g.Generate(it, fs); err != nil {
+		t.Fatal(err)
+	}
+	act := string(pretty(t, g.buf.Bytes()))
+
+	for _, ex := range []string{
+		"\tparent     *dicontainer\n",
+		"func (d *dicontainer) NewScope() DIContainer {\n\ts := &dicontainer{parent: d, opts: d.opts}\n\tfor _, opt := range d.opts {\n\t\topt(s)\n\t}\n\treturn s\n}",
+		"func WithLogger(instance Logger) DIContainerOption {\n",
+		"func WithSession(instance Session) DIContainerOption {\n",
+		"func (d *dicontainer) Logger() (Logger, error) {\n\tif d.parent != nil {\n\t\treturn d.parent.Logger()\n\t}\n\tc := &d.components.logger\n",
+		"func (d *dicontainer) Session() (Session, error) {\n\tif d.parent == nil {\n\t\treturn nil, errors.New(\"Session is request scoped: resolve it from NewScope()\")\n\t}\n\tc := &d.components.session\n",
+		"func (d *dicontainer) Handler() (Handler, error) {\n\tinstance, err := NewHandler()\n",
+	} {
+		if !strings.Contains(act, ex) {
+			t.Errorf("%q not found in:\n%s", ex, act)
+		}
+	}
+	if strings.Contains(act, "handler struct") || strings.Contains(act, "WithHandler") {
+		t.Errorf("transient component must not be cached:\n%s", act)
+	}
+}
+
+func TestGenerateContainerName(t *testing.T) {
+	it := &InterfaceType{
+		Name:        "WorkerContainer",
+		PackageName: "di",
+	}
+	g := NewGenerator()
+	if err := g.Generate(it, nil); err != nil {
+		t.Fatal(err)
+	}
+	act := string(pretty(t, g.buf.Bytes()))
+	for _, ex := range []string{
+		"type workercontainer struct {",
+		"func NewWorkerContainer(opts ...WorkerContainerOption) WorkerContainer {\n\td := &workercontainer{}\n",
+	} {
+		if !strings.Contains(act, ex) {
+			t.Errorf("%q not found in:\n%s", ex, act)
+		}
+	}
+
+	it.StructName = "worker"
+	it.Constructor = "NewWorker"
+	g = NewGenerator()
+	if err := g.Generate(it, nil); err != nil {
+		t.Fatal(err)
+	}
+	act = string(pretty(t, g.buf.Bytes()))
+	if ex := "type worker struct {"; !strings.Contains(act, ex) {
+		t.Errorf("%q not found in:\n%s", ex, act)
+	}
+	if ex := "func NewWorker(opts ...WorkerContainerOption) WorkerContainer {\n\td := &worker{}\n"; !strings.Contains(act, ex) {
+		t.Errorf("%q not found in:\n%s", ex, act)
+	}
+	it.Name = "container"
+	it.StructName = ""
+	if n := containerStructName(it); n != "containerImpl" {
+		t.Errorf("the struct must not collide with the interface container, but %s", n)
+	}
+}
+
+var TEST_RACE_COMPONENTS = `
+package race
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	createdA int32
+	createdB int32
+	failC    int32 = 1
+)
+
+type A interface{}
+
+type B interface{}
+
+type C interface{}
+
+// +DICON
+type Container interface {
+	A() (A, error)
+	B() (B, error)
+	C() (C, error)
+}
+
+func NewA() (A, error) {
+	atomic.AddInt32(&createdA, 1)
+	time.Sleep(10 * time.Millisecond)
+	return new(int), nil
+}
+
+func NewB(a A) (B, error) {
+	atomic.AddInt32(&createdB, 1)
+	return new(int), nil
+}
+
+func NewC(a A) (C, error) {
+	if atomic.CompareAndSwapInt32(&failC, 1, 0) {
+		return nil, errors.New("first call fails")
+	}
+	return new(int), nil
+}
+`
+
+var TEST_RACE_TEST = `
+package race
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConcurrentResolution(t *testing.T) {
+	d := NewContainer()
+	var wg sync.WaitGroup
+	res := make([]B, 32)
+	for i := range res {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b, err := d.B()
+			if err != nil {
+				t.Error(err)
+			}
+			res[i] = b
+		}(i)
+	}
+	wg.Wait()
+
+	if createdA != 1 || createdB != 1 {
+		t.Errorf("singletons must be built once, but A: %d, B: %d", createdA, createdB)
+	}
+	for _, b := range res {
+		if b != res[0] {
+			t.Errorf("all callers must share one instance")
+		}
+	}
+
+	if _, err := d.C(); err == nil {
+		t.Errorf("first resolution of C must fail")
+	}
+	if _, err := d.C(); err != nil {
+		t.Errorf("failed resolution must be retried, but %v", err)
+	}
+}
+`
+
+var TEST_NAMES_COMPONENTS = `
+package names
+
+import (
+	"context"
+)
+
+type Options struct {
+	Name string
+}
+
+type Parent struct {
+	Name   string
+	Closed bool
+}
+
+func (p *Parent) Close() error {
+	p.Closed = true
+	return nil
+}
+
+type Closers struct{}
+
+type Logger struct{}
+
+// +DICON
+type Container interface {
+	// +DICON:config
+	Opts() (Options, error)
+	Parent() (*Parent, error)
+	Closers() (*Closers, error)
+	logger() (*Logger, error)
+	NewScope() Container
+	Close(ctx context.Context) error
+}
+
+func NewParent(o Options, l *Logger) *Parent {
+	return &Parent{Name: o.Name}
+}
+
+func NewClosers() *Closers {
+	return &Closers{}
+}
+
+func NewLogger() *Logger {
+	return &Logger{}
+}
+`
+
+var TEST_NAMES_TEST = `
+package names
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNames(t *testing.T) {
+	d := NewContainer(Options{Name: "root"})
+	p, err := d.NewScope().Parent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "root" {
+		t.Errorf("Opts must be injected but %+v", p)
+	}
+	if _, err := d.Closers(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(context.Background()); err != nil || !p.Closed {
+		t.Errorf("Parent must be closed: %v", err)
+	}
+}
+`
+
+var TEST_KEYWORD_COMPONENTS = `
+package keywords
+
+type Mapper struct{}
+
+type Options struct {
+	Name string
+}
+
+type Runner struct {
+	Mapper *Mapper
+	Name   string
+}
+
+// +DICON
+type Container interface {
+	// +DICON:config
+	Type() (Options, error)
+	Map() (*Mapper, error)
+	Go() (*Runner, error)
+}
+
+func NewMap() *Mapper {
+	return &Mapper{}
+}
+
+func NewGo(m *Mapper, name string) *Runner {
+	return &Runner{Mapper: m, Name: name}
+}
+`
+
+var TEST_KEYWORD_TEST = `
+package keywords
+
+import (
+	"testing"
+)
+
+func TestKeywords(t *testing.T) {
+	d := NewContainer(Options{Name: "runner"})
+	r, err := d.Go()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := d.Map(); r.Mapper != m {
+		t.Error("Map must be cached")
+	}
+	if r.Name != "runner" {
+		t.Errorf("the config Type must be injected but %+v", r)
+	}
+}
+`
+
+var TEST_POINTER_CONFIG_COMPONENTS = `
+package pointerconfigs
+
+import (
+	"time"
+)
+
+type Config struct {
+	Timeout time.Duration
+}
+
+type HTTPClient struct {
+	Timeout time.Duration
+}
+
+// +DICON
+type Container interface {
+	// +DICON:config
+	Config() (*Config, error)
+	HTTPClient() (*HTTPClient, error)
+}
+
+func NewHTTPClient(timeout time.Duration) *HTTPClient {
+	return &HTTPClient{Timeout: timeout}
+}
+`
+
+var TEST_POINTER_CONFIG_TEST = `
+package pointerconfigs
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPointerConfig(t *testing.T) {
+	c, err := NewContainer(&Config{Timeout: time.Second}).HTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Timeout != time.Second {
+		t.Errorf("config must be injected but %+v", c)
+	}
+	if _, err := NewContainer(nil).HTTPClient(); err == nil || !strings.Contains(err.Error(), "config Config is nil") {
+		t.Errorf("nil config must be an error: %v", err)
+	}
+}
+`
+
+var TEST_FALLBACK_COMPONENTS = `
+package fallbacks
+
+import (
+	"strings"
+)
+
+// +DICON
+type Container interface {
+	Replacer() (*strings.Replacer, error)
+}
+
+func NewReplacer() *strings.Replacer {
+	return strings.NewReplacer("dicon", "DICON")
+}
+`
+
+var TEST_FALLBACK_TEST = `
+package fallbacks
+
+import (
+	"testing"
+)
+
+func TestFallback(t *testing.T) {
+	r, err := NewContainer().Replacer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := r.Replace("dicon"); s != "DICON" {
+		t.Errorf("the local NewReplacer must win over strings.NewReplacer, but %s", s)
+	}
+}
+`
+
+// TestGenerateCompiled generates the containers of the fixtures with the
+// pipeline of "dicon generate", then runs the tests of the fixtures against
+// them, under the race detector when it is available.
+func TestGenerateCompiled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skip compiling generated code in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+
+	cases := []struct {
+		name       string
+		components string
+		test       string
+	}{
+		{"concurrentResolution", TEST_RACE_COMPONENTS, TEST_RACE_TEST},
+		{"close", TEST_CLOSE_COMPONENTS, TEST_CLOSE_TEST},
+		{"cleanup", TEST_CLEANUP_COMPONENTS, TEST_CLEANUP_TEST},
+		{"context", TEST_CONTEXT_COMPONENTS, TEST_CONTEXT_TEST},
+		{"valueTypes", TEST_VALUE_COMPONENTS, TEST_VALUE_TEST},
+		{"config", TEST_CONFIG_COMPONENTS, TEST_CONFIG_TEST},
+		{"provider", TEST_PROVIDER_COMPONENTS, TEST_PROVIDER_TEST},
+		{"impl", TEST_IMPL_COMPONENTS, TEST_IMPL_TEST},
+		{"bind", TEST_BIND_COMPONENTS, TEST_BIND_TEST},
+		{"embedded", TEST_EMBED_COMPONENTS, TEST_EMBED_TEST},
+		{"scopedEmbedded", TEST_SCOPED_EMBED_COMPONENTS, TEST_SCOPED_EMBED_TEST},
+		{"group", TEST_GROUP_COMPONENTS, TEST_GROUP_TEST},
+		{"optional", TEST_OPTIONAL_COMPONENTS, TEST_OPTIONAL_TEST},
+		{"lazy", TEST_LAZY_COMPONENTS, TEST_LAZY_TEST},
+		{"decorate", TEST_DECORATE_COMPONENTS, TEST_DECORATE_TEST},
+		{"override", TEST_OVERRIDE_COMPONENTS, TEST_OVERRIDE_TEST},
+		{"fallback", TEST_FALLBACK_COMPONENTS, TEST_FALLBACK_TEST},
+		{"names", TEST_NAMES_COMPONENTS, TEST_NAMES_TEST},
+		{"pointerConfig", TEST_POINTER_CONFIG_COMPONENTS, TEST_POINTER_CONFIG_TEST},
+		{"keywords", TEST_KEYWORD_COMPONENTS, TEST_KEYWORD_TEST},
+	}
+
+	// the fixtures live in a module of their own, requiring the version of
+	// github.com/pkg/errors this module builds with.
+	dir := t.TempDir()
+	writeFixtureModule(t, dir)
+
+	var paths []string
+	for _, c := range cases {
+		paths = append(paths, "./"+c.name)
+		if err := os.Mkdir(filepath.Join(dir, c.name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(dir, c.name, "components.go"), c.components)
+		writeTestFile(t, filepath.Join(dir, c.name, "generated_test.go"), c.test)
+	}
+
+	first := generateFixtures(t, dir, paths)
+	// generating again must ignore the files generated first, whose
+	// New<Container> is not the constructor of NewScope.
+	second := generateFixtures(t, dir, paths)
+	for name, src := range first {
+		if !bytes.Equal(src, second[name]) {
+			t.Errorf("%s: regeneration differs:\n%s", name, diff.LineDiff(string(src), string(second[name])))
+		}
+	}
+
+	args := []string{"test", "-count=1"}
+	if raceSupported() {
+		args = append(args, "-race")
+	}
+	cmd := exec.Command("go", append(args, paths...)...)
+	cmd.Dir = dir
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, b)
+	}
+}
+
+// generateFixtures writes the container of every fixture package into its
+// dicon_gen.go, and returns the generated sources by package name.
+func generateFixtures(t *testing.T, dir string, paths []string) map[string][]byte {
+	t.Helper()
+	pkgs, err := loadPackagesIn(dir, paths...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[string][]byte, len(pkgs))
+	for _, pkg := range pkgs {
+		its, err := NewPackageParser(pkg).FindDicons()
+		if err != nil || len(its) != 1 {
+			t.Fatalf("%s: +DICON not found: %v", pkg.Name, err)
+		}
+		// the constructors of the other fixtures are out of scope.
+		g, err := GenerateContainer([]*packages.Package{pkg}, &its[0])
+		if err != nil {
+			t.Fatalf("%s: %v", pkg.Name, err)
+		}
+		var buf bytes.Buffer
+		filename := filepath.Join(its[0].PackageDir, "dicon_gen.go")
+		if err := g.Out(&buf, filename); err != nil {
+			t.Fatalf("%s: %v", pkg.Name, err)
+		}
+		writeTestFile(t, filename, buf.String())
+		res[pkg.Name] = buf.Bytes()
+	}
+	return res
+}
+
+// writeFixtureModule declares the module of the fixtures in dir, with the
+// go.sum of this module.
+func writeFixtureModule(t *testing.T, dir string) {
+	t.Helper()
+	version, err := exec.Command("go", "list", "-m", "-f", "{{.Version}}", "github.com/pkg/errors").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gomod, err := exec.Command("go", "env", "GOMOD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := os.ReadFile(filepath.Join(filepath.Dir(strings.TrimSpace(string(gomod))), "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "go.mod"), fmt.Sprintf("module fixtures\n\ngo 1.22\n\nrequire github.com/pkg/errors %s\n", strings.TrimSpace(string(version))))
+	writeTestFile(t, filepath.Join(dir, "go.sum"), string(sum))
+}
+
+// raceSupported reports whether the race detector, which requires cgo, is
+// available.
+func raceSupported() bool {
+	b, err := exec.Command("go", "env", "CGO_ENABLED").Output()
+	return err == nil && strings.TrimSpace(string(b)) == "1"
+}
+
+func writeTestFile(t *testing.T, name, src string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var TEST_CLOSE_COMPONENTS = `
+package closer
+
+import (
+	"context"
+	"errors"
+)
+
+var closed []string
+
+type DB struct{}
+
+func (*DB) Close() error {
+	closed = append(closed, "DB")
+	return errors.New("db close failed")
+}
+
+type Server struct{}
+
+func (*Server) Shutdown(ctx context.Context) error {
+	closed = append(closed, "Server")
+	return nil
+}
+
+type Client interface {
+	Close(ctx context.Context) error
+}
+
+type client struct{}
+
+func (client) Close(ctx context.Context) error {
+	closed = append(closed, "Client")
+	return nil
+}
+
+// +DICON
+type Container interface {
+	DB() (*DB, error)
+	Client() (Client, error)
+	Server() (*Server, error)
+	Close(ctx context.Context) error
+}
+
+func NewDB() (*DB, error) {
+	return &DB{}, nil
+}
+
+func NewClient() (Client, error) {
+	return client{}, nil
+}
+
+func NewServer(db *DB, c Client) (*Server, error) {
+	return &Server{}, nil
+}
+`
+
+var TEST_CLOSE_TEST = `
+package closer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestClose(t *testing.T) {
+	d := NewContainer()
+	if _, err := d.Server(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.DB(); err != nil {
+		t.Fatal(err)
+	}
+
+	err := d.Close(context.Background())
+	if err == nil || err.Error() != "close DB failed at DICON: db close failed" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if ex := []string{"Server", "Client", "DB"}; !reflect.DeepEqual(closed, ex) {
+		t.Errorf("must be closed in %v but %v", ex, closed)
+	}
+	if err := d.Close(context.Background()); err != nil {
+		t.Errorf("components must be closed only once: %v", err)
+	}
+}
+`
+
+var TEST_CLEANUP_COMPONENTS = `
+package cleanup
+
+var cleaned []string
+
+type Pool struct{}
+
+type Cache interface{}
+
+// +DICON
+type Container interface {
+	Pool() (*Pool, error)
+	Cache() (Cache, error)
+}
+
+func NewPool() (*Pool, func(), error) {
+	return &Pool{}, func() { cleaned = append(cleaned, "Pool") }, nil
+}
+
+func NewCache(p *Pool) (Cache, func(), error) {
+	return new(int), func() { cleaned = append(cleaned, "Cache") }, nil
+}
+`
+
+var TEST_CLEANUP_TEST = `
+package cleanup
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCleanup(t *testing.T) {
+	d := NewContainer()
+	for i := 0; i < 2; i++ {
+		if _, err := d.Cache(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.(interface{ Close(context.Context) error }).Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ex := []string{"Cache", "Pool"}; !reflect.DeepEqual(cleaned, ex) {
+		t.Errorf("must be cleaned up in %v but %v", ex, cleaned)
+	}
+}
+`
+
+var TEST_CONTEXT_COMPONENTS = `
+package ctxs
+
+import (
+	"context"
+)
+
+type key struct{}
+
+type DB struct {
+	Value interface{}
+}
+
+type Service struct {
+	DB *DB
+}
+
+type Handler struct {
+	Service *Service
+	Value   interface{}
+}
+
+// +DICON
+type Container interface {
+	// +DICON:scope=transient
+	DB(ctx context.Context) (*DB, error)
+	// +DICON:scope=transient
+	Service() (*Service, error)
+	Handler(ctx context.Context) (*Handler, error)
+}
+
+func NewDB(ctx context.Context) (*DB, error) {
+	return &DB{Value: ctx.Value(key{})}, nil
+}
+
+func NewService(db *DB) (*Service, error) {
+	return &Service{DB: db}, nil
+}
+
+func NewHandler(ctx context.Context, s *Service) (*Handler, error) {
+	return &Handler{Service: s, Value: ctx.Value(key{})}, nil
+}
+`
+
+var TEST_CONTEXT_TEST = `
+package ctxs
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContext(t *testing.T) {
+	d := NewContainer()
+	ctx := context.WithValue(context.Background(), key{}, "v")
+
+	db, err := d.DB(ctx)
+	if err != nil || db.Value != "v" {
+		t.Errorf("ctx must be passed to NewDB: %v, %v", db, err)
+	}
+	h, err := d.Handler(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Value != "v" {
+		t.Errorf("ctx must be passed to NewHandler but %v", h.Value)
+	}
+	if h.Service.DB.Value != nil {
+		t.Errorf("Service must resolve DB with a background context but %v", h.Service.DB.Value)
+	}
+}
+`
+
+var TEST_VALUE_COMPONENTS = `
+package values
+
+import (
+	"errors"
+)
+
+type Config struct {
+	Port int
+}
+
+type Port int
+
+type Ready bool
+
+// +DICON
+type Container interface {
+	Config() (Config, error)
+	Port() (Port, error)
+	Ready() (Ready, error)
+}
+
+func NewConfig() Config {
+	return Config{Port: 8080}
+}
+
+func NewPort(c Config) (Port, error) {
+	return Port(c.Port), nil
+}
+
+func NewReady(p Port) (Ready, error) {
+	return false, errors.New("not ready")
+}
+`
+
+var TEST_VALUE_TEST = `
+package values
+
+import (
+	"testing"
+)
+
+func TestValues(t *testing.T) {
+	d := NewContainer()
+	p, err := d.Port()
+	if err != nil || p != 8080 {
+		t.Errorf("unexpected port: %v, %v", p, err)
+	}
+	if r, err := d.Ready(); err == nil || r {
+		t.Errorf("Ready must fail with the zero value: %v, %v", r, err)
+	}
+}
+`
+
+var TEST_CONFIG_COMPONENTS = `
+package configs
+
+import (
+	"time"
+)
+
+type Config struct {
+	Timeout time.Duration
+	BaseURL string ` + "`dicon:\"url\"`" + `
+}
+
+type HTTPClient struct {
+	Timeout time.Duration
+	URL     string
+}
+
+type Session struct {
+	Client *HTTPClient
+}
+
+// +DICON
+type Container interface {
+	// +DICON:config
+	Config() (Config, error)
+	HTTPClient() (*HTTPClient, error)
+	// +DICON:scope=request
+	Session() (*Session, error)
+	NewScope() Container
+}
+
+func NewHTTPClient(timeout time.Duration, url string) *HTTPClient {
+	return &HTTPClient{Timeout: timeout, URL: url}
+}
+
+func NewSession(c *HTTPClient) *Session {
+	return &Session{Client: c}
+}
+`
+
+var TEST_CONFIG_TEST = `
+package configs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	d := NewContainer(Config{Timeout: time.Second, BaseURL: "http://example.com"})
+	s, err := d.NewScope().Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Client.Timeout != time.Second || s.Client.URL != "http://example.com" {
+		t.Errorf("config must be injected but %+v", s.Client)
+	}
+	if c, _ := d.NewScope().Config(); c.Timeout != time.Second {
+		t.Errorf("scope must share the config but %+v", c)
+	}
+	if _, err := d.Session(); err == nil {
+		t.Error("request scoped Session must not be resolved from the root container")
+	}
+}
+`
+
+var TEST_PROVIDER_COMPONENTS = `
+package providers
+
+import (
+	"strings"
+)
+
+type Config struct {
+	Pairs []string ` + "`dicon:\"oldnew\"`" + `
+}
+
+type Repository interface {
+	Name() string
+}
+
+type repository struct{}
+
+func (repository) Name() string { return "repository" }
+
+type Service struct {
+	Replacer *strings.Replacer
+	Repo     Repository
+}
+
+// +DICON
+type Container interface {
+	// +DICON:config
+	Config() (Config, error)
+	// +DICON:provider=strings.NewReplacer
+	Replacer() (*strings.Replacer, error)
+	Repository() (Repository, error)
+	// +DICON:provider=buildService
+	Service() (*Service, error)
+}
+
+// +DICON:provide Repository
+func openRepository() (*repository, error) {
+	return &repository{}, nil
+}
+
+func buildService(r *strings.Replacer, repo Repository) *Service {
+	return &Service{Replacer: r, Repo: repo}
+}
+`
+
+var TEST_PROVIDER_TEST = `
+package providers
+
+import (
+	"testing"
+)
+
+func TestProvider(t *testing.T) {
+	d := NewContainer(Config{Pairs: []string{"a", "b"}})
+	s, err := d.Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Replacer.Replace("abc"); got != "bbc" {
+		t.Errorf("replacer must be built by strings.NewReplacer but %s", got)
+	}
+	if s.Repo.Name() != "repository" {
+		t.Errorf("repository must be built by openRepository")
+	}
+}
+`
+
+var TEST_IMPL_COMPONENTS = `
+package impls
+
+type Repository interface {
+	Name() string
+}
+
+type repository struct{}
+
+func (*repository) Name() string { return "repository" }
+
+type Logger struct{}
+
+type UserService interface {
+	Repository() Repository
+}
+
+type userService struct {
+	repo   Repository ` + "`dicon:\"inject\"`" + `
+	Logger *Logger
+}
+
+func (s *userService) Repository() Repository { return s.repo }
+
+type Handler struct {
+	Users  UserService
+	Logger *Logger
+	Name   string ` + "`dicon:\"-\"`" + `
+}
+
+// +DICON
+type Container interface {
+	Repository() (Repository, error)
+	Logger() (*Logger, error)
+	// +DICON:impl userService
+	UserService() (UserService, error)
+	// +DICON:impl=Handler
+	Handler() (Handler, error)
+}
+
+func NewRepository() (Repository, error) {
+	return &repository{}, nil
+}
+
+func NewLogger() *Logger {
+	return &Logger{}
+}
+`
+
+var TEST_IMPL_TEST = `
+package impls
+
+import (
+	"testing"
+)
+
+func TestImpl(t *testing.T) {
+	d := NewContainer()
+	h, err := d.Handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Users.Repository().Name() != "repository" {
+		t.Errorf("repo must be injected into userService")
+	}
+	if h.Users.(*userService).Logger != nil {
+		t.Errorf("untagged Logger of userService must not be injected")
+	}
+	if h.Logger == nil {
+		t.Errorf("exported Logger of Handler must be injected")
+	}
+}
+`
+
+var TEST_BIND_COMPONENTS = `
+package binds
+
+type UserRepository interface {
+	Find() string
+}
+
+type PostgresUserRepo struct {
+	DSN string
+}
+
+func (r *PostgresUserRepo) Find() string { return "postgres" }
+
+// +DICON
+type Container interface {
+	// +DICON:bind=*PostgresUserRepo
+	UserRepository() (UserRepository, error)
+}
+
+func NewPostgresUserRepo() (*PostgresUserRepo, error) {
+	return &PostgresUserRepo{}, nil
+}
+`
+
+var TEST_BIND_TEST = `
+package binds
+
+import (
+	"testing"
+)
+
+func TestBind(t *testing.T) {
+	r, err := NewContainer().UserRepository()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.(*PostgresUserRepo); !ok || r.Find() != "postgres" {
+		t.Errorf("UserRepository must be built by NewPostgresUserRepo but %T", r)
+	}
+}
+`
+
+var TEST_EMBED_COMPONENTS = `
+package embeds
+
+type DB struct{}
+
+type Server struct {
+	DB *DB
+}
+
+type CoreContainer interface {
+	DB() (*DB, error)
+}
+
+// +DICON
+type Container interface {
+	CoreContainer
+	Server() (*Server, error)
+}
+
+func NewDB() *DB {
+	return &DB{}
+}
+
+func NewServer(db *DB) *Server {
+	return &Server{DB: db}
+}
+`
+
+var TEST_EMBED_TEST = `
+package embeds
+
+import (
+	"testing"
+)
+
+func TestEmbed(t *testing.T) {
+	var core CoreContainer = NewContainer()
+	if _, err := core.DB(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewContainer().Server()
+	if err != nil || s.DB == nil {
+		t.Errorf("DB of CoreContainer must be injected: %v", err)
+	}
+}
+`
+
+var TEST_SCOPED_EMBED_COMPONENTS = `
+package scopedembeds
+
+type DB struct{}
+
+type Session struct {
+	DB *DB
+}
+
+type Server struct {
+	DB *DB
+}
+
+type BaseContainer interface {
+	DB() (*DB, error)
+	NewScope() BaseContainer
+}
+
+type CoreContainer interface {
+	BaseContainer
+	// +DICON:scope=request
+	Session() (*Session, error)
+}
+
+// +DICON
+type Container interface {
+	CoreContainer
+	Server() (*Server, error)
+}
+
+func NewDB() *DB {
+	return &DB{}
+}
+
+func NewSession(db *DB) *Session {
+	return &Session{DB: db}
+}
+
+func NewServer(db *DB) *Server {
+	return &Server{DB: db}
+}
+`
+
+var TEST_SCOPED_EMBED_TEST = `
+package scopedembeds
+
+import (
+	"testing"
+)
+
+func TestScopedEmbed(t *testing.T) {
+	d := NewContainer()
+	scope, ok := d.NewScope().(Container)
+	if !ok {
+		t.Fatal("the scope must implement Container")
+	}
+	s1, err := scope.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2, _ := scope.Session(); s1 != s2 {
+		t.Error("Session must be cached in its scope")
+	}
+	if s3, _ := d.NewScope().(Container).Session(); s3 == s1 {
+		t.Error("Session must not be shared between scopes")
+	}
+	db, _ := d.DB()
+	if srv, _ := scope.Server(); srv.DB != db || s1.DB != db {
+		t.Error("DB must be shared with the parent")
+	}
+}
+`
+
+var TEST_GROUP_COMPONENTS = `
+package groups
+
+type Handler interface {
+	Path() string
+}
+
+type handler string
+
+func (h handler) Path() string { return string(h) }
+
+type Router struct {
+	Handlers []Handler
+	ByName   map[string]Handler
+	Mounted  []Handler
+}
+
+type Mux struct {
+	Handlers []Handler
+}
+
+// +DICON
+type Container interface {
+	// +DICON:group=handlers
+	Users() (Handler, error)
+	Orders() (Handler, error)
+	Router() (*Router, error)
+	Mux() (*Mux, error)
+}
+
+func NewUsers() Handler {
+	return handler("/users")
+}
+
+// +DICON:group=handlers
+func NewOrders() Handler {
+	return handler("/orders")
+}
+
+func NewRouter(handlers []Handler, byName map[string]Handler) *Router {
+	return &Router{Handlers: handlers, ByName: byName}
+}
+
+func NewMux(handlers ...Handler) *Mux {
+	return &Mux{Handlers: handlers}
+}
+
+// +DICON:decorate Router
+func MountHandlers(r *Router, handlers ...Handler) *Router {
+	r.Mounted = handlers
+	return r
+}
+`
+
+var TEST_GROUP_TEST = `
+package groups
+
+import (
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	r, err := NewContainer().Router()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Handlers) != 2 || r.Handlers[0].Path() != "/users" || r.Handlers[1].Path() != "/orders" {
+		t.Errorf("handlers must be injected in container order: %v", r.Handlers)
+	}
+	if len(r.ByName) != 2 || r.ByName["Orders"].Path() != "/orders" {
+		t.Errorf("handlers must be keyed by method: %v", r.ByName)
+	}
+	if len(r.Mounted) != 2 {
+		t.Errorf("handlers must be passed to a variadic decorator: %v", r.Mounted)
+	}
+	m, err := NewContainer().Mux()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Handlers) != 2 || m.Handlers[1].Path() != "/orders" {
+		t.Errorf("handlers must be passed to a variadic constructor: %v", m.Handlers)
+	}
+}
+`
+
+var TEST_OPTIONAL_COMPONENTS = `
+package optionals
+
+type Logger interface {
+	Log(string)
+}
+
+type Metrics struct{}
+
+type Options struct {
+	Retries int
+}
+
+type Service struct {
+	Logger  Logger
+	Metrics *Metrics
+	Options Options
+}
+
+// +DICON
+type Container interface {
+	Metrics() (*Metrics, error)
+	Service() (*Service, error)
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// +DICON:optional logger metrics options
+func NewService(logger Logger, metrics *Metrics, options Options) *Service {
+	return &Service{Logger: logger, Metrics: metrics, Options: options}
+}
+`
+
+var TEST_OPTIONAL_TEST = `
+package optionals
+
+import (
+	"testing"
+)
+
+func TestOptional(t *testing.T) {
+	s, err := NewContainer().Service()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Logger != nil || s.Options.Retries != 0 {
+		t.Errorf("missing optional dependencies must be zero: %+v", s)
+	}
+	if s.Metrics == nil {
+		t.Error("provided optional dependency must be injected")
+	}
+}
+`
+
+var TEST_LAZY_COMPONENTS = `
+package lazies
+
+import (
+	"context"
+)
+
+type UserService struct {
+	Orders func() (*OrderService, error)
+	Audit  func() (*Audit, error)
+}
+
+type OrderService struct {
+	Users *UserService
+}
+
+type Audit struct{}
+
+// +DICON
+type Container interface {
+	UserService(ctx context.Context) (*UserService, error)
+	OrderService() (*OrderService, error)
+	Audit(ctx context.Context) (*Audit, error)
+}
+
+func NewUserService(orders func() (*OrderService, error), audit func() (*Audit, error)) *UserService {
+	return &UserService{Orders: orders, Audit: audit}
+}
+
+func NewAudit(ctx context.Context) (*Audit, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &Audit{}, nil
+}
+
+func NewOrderService(users *UserService) *OrderService {
+	return &OrderService{Users: users}
+}
+`
+
+var TEST_LAZY_TEST = `
+package lazies
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLazy(t *testing.T) {
+	di := NewContainer()
+	ctx, cancel := context.WithCancel(context.Background())
+	u, err := di.UserService(ctx)
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := u.Orders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Users != u {
+		t.Error("the thunk must resolve the component of the container")
+	}
+	if o2, _ := di.OrderService(); o2 != o {
+		t.Error("the thunk must return the cached component")
+	}
+	if _, err := u.Audit(); err != nil {
+		t.Errorf("the thunk must not depend on the context of the caller: %v", err)
+	}
+}
+`
+
+var TEST_DECORATE_COMPONENTS = `
+package decorators
+
+type UserService interface {
+	Name() string
+}
+
+type userService struct{}
+
+func (userService) Name() string { return "users" }
+
+type wrapped struct {
+	UserService
+	by string
+}
+
+func (w wrapped) Name() string { return w.by + "(" + w.UserService.Name() + ")" }
+
+type Metrics struct{}
+
+// +DICON
+type Container interface {
+	Metrics() (*Metrics, error)
+	UserService() (UserService, error)
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{}
+}
+
+func NewUserService() UserService {
+	return userService{}
+}
+
+// +DICON:decorate order=2
+func DecorateMetrics(s UserService, m *Metrics) (UserService, error) {
+	return wrapped{UserService: s, by: "metrics"}, nil
+}
+
+// +DICON:decorate UserService order=1
+func DecorateLogging(s UserService) UserService {
+	return wrapped{UserService: s, by: "logging"}
+}
+`
+
+var TEST_DECORATE_TEST = `
+package decorators
+
+import (
+	"testing"
+)
+
+func TestDecorate(t *testing.T) {
+	di := NewContainer()
+	s, err := di.UserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name() != "metrics(logging(users))" {
+		t.Errorf("decorators must be applied by order but %s", s.Name())
+	}
+	if s2, _ := di.UserService(); s2 != s {
+		t.Error("the decorated component must be cached")
+	}
+}
+`
+
+var TEST_OVERRIDE_COMPONENTS = `
+package overrides
+
+type UserRepository interface {
+	Find() string
+}
+
+type userRepository struct{}
+
+func (userRepository) Find() string { return "db" }
+
+type UserService struct {
+	Repo UserRepository
+}
+
+type Session struct{}
+
+// +DICON option=Override
+type Container interface {
+	UserRepository() (UserRepository, error)
+	UserService() (*UserService, error)
+	// +DICON:scope=request
+	Session() (*Session, error)
+	NewScope() Container
+}
+
+func NewUserRepository() (UserRepository, error) {
+	return userRepository{}, nil
+}
+
+func NewUserService(repo UserRepository) *UserService {
+	return &UserService{Repo: repo}
+}
+
+func NewSession() *Session {
+	return &Session{}
+}
+`
+
+var TEST_OVERRIDE_TEST = `
+package overrides
+
+import (
+	"testing"
+)
+
+type mockRepository struct{}
+
+func (mockRepository) Find() string { return "mock" }
+
+func TestOverride(t *testing.T) {
+	session := &Session{}
+	di := NewContainer(OverrideUserRepository(mockRepository{}), OverrideSession(session))
+	s, err := di.UserService()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Repo.Find() != "mock" {
+		t.Errorf("UserRepository must be overridden but %s", s.Repo.Find())
+	}
+	if s2, err := di.NewScope().Session(); err != nil || s2 != session {
+		t.Errorf("request scoped Session must be overridden in the scopes: %v", err)
+	}
+	if r, _ := NewContainer().UserRepository(); r.Find() != "db" {
+		t.Errorf("UserRepository must be built without options but %s", r.Find())
+	}
+}
+`
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"go/types"
+)
+
+// groupsOf returns the groups the container method m contributes to, from
+// the "+DICON:group=<name>" annotations of m and of its constructor f.
+func groupsOf(m, f FuncType) []string {
+	var res []string
+	for _, cs := range []comments{m.Comments, f.Comments} {
+		for _, a := range cs.annotations("group") {
+			names := a.Args
+			if a.Value != "" {
+				names = append([]string{a.Value}, names...)
+			}
+			for _, n := range names {
+				if !contains(n, res) {
+					res = append(res, n)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// addGroups registers the members of every group, in the order of the
+// container methods so that the generated slices are deterministic.
+func (idx *providerIndex) addGroups(funcs []FuncType) {
+	idx.groups = map[string][]string{}
+	for _, m := range idx.methods {
+		var f FuncType
+		if i := indexOfFunc(m.Name, funcs); i >= 0 {
+			f = funcs[i]
+		}
+		for _, g := range groupsOf(m, f) {
+			if !contains(g, idx.groupNames) {
+				idx.groupNames = append(idx.groupNames, g)
+			}
+			idx.groups[g] = append(idx.groups[g], m.Name)
+		}
+	}
+}
+
+// lookupGroup resolves p, a []T or a map[string]T, to the group whose
+// members are all assignable to T. A group named like the argument wins.
+func (idx *providerIndex) lookupGroup(p ParameterType) (Dependency, []string, bool) {
+	elem, keyed, ok := groupElem(p)
+	if !ok {
+		return Dependency{}, nil, false
+	}
+	var candidates []string
+	for _, g := range idx.groupNames {
+		if idx.assignableGroup(g, elem) {
+			candidates = append(candidates, g)
+		}
+	}
+	if len(candidates) > 1 {
+		candidates = qualifyByName(candidates, p.Name)
+	}
+	if len(candidates) != 1 {
+		return Dependency{}, candidates, false
+	}
+	return Dependency{Group: candidates[0], Members: idx.groups[candidates[0]], Keyed: keyed}, nil, true
+}
+
+func (idx *providerIndex) assignableGroup(name string, elem types.Type) bool {
+	for _, member := range idx.groups[name] {
+		m, _ := idx.method(member)
+		t := m.ReturnTypes[0].Type()
+		if t == nil || !types.AssignableTo(t, elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// groupElem returns the element type of a []T or a map[string]T, keyed by
+// the container method names.
+func groupElem(p ParameterType) (types.Type, bool, bool) {
+	t := p.Type()
+	if t == nil {
+		return nil, false, false
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Slice:
+		return u.Elem(), false, true
+	case *types.Map:
+		if b, ok := u.Key().Underlying().(*types.Basic); ok && b.Kind() == types.String {
+			return u.Elem(), true, true
+		}
+	}
+	return nil, false, false
+}
+
+func groupCandidates(groups []string) []string {
+	res := make([]string, 0, len(groups))
+	for _, g := range groups {
+		res = append(res, "group "+g)
+	}
+	return res
+}
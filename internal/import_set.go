@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// importSet assigns a local name to every package referenced from the
+// generated file. Packages whose names collide get a numbered alias.
+type importSet struct {
+	reserved map[string]string
+	aliases  map[string]string
+	pkgNames map[string]string
+	paths    map[string]string
+}
+
+func newImportSet() *importSet {
+	return &importSet{
+		reserved: map[string]string{},
+		aliases:  map[string]string{},
+		pkgNames: map[string]string{},
+		paths:    map[string]string{},
+	}
+}
+
+// reserve registers a package which the generator imports by itself, so
+// that user packages with the same name are aliased instead.
+func (s *importSet) reserve(path, name string) {
+	s.reserved[path] = name
+	s.paths[name] = path
+}
+
+func (s *importSet) name(path, pkgName string) string {
+	if n, ok := s.reserved[path]; ok {
+		return n
+	}
+	if n, ok := s.aliases[path]; ok {
+		return n
+	}
+	alias := pkgName
+	for i := 2; ; i++ {
+		if _, ok := s.paths[alias]; !ok {
+			break
+		}
+		alias = pkgName + strconv.Itoa(i)
+	}
+	s.aliases[path] = alias
+	s.pkgNames[path] = pkgName
+	s.paths[alias] = path
+	return alias
+}
+
+func (s *importSet) has(path string) bool {
+	_, ok := s.reserved[path]
+	if !ok {
+		_, ok = s.aliases[path]
+	}
+	return ok
+}
+
+// specs returns the import specs of the non reserved packages sorted by
+// path, naming the package explicitly when an alias was assigned.
+func (s *importSet) specs() []string {
+	paths := make([]string, 0, len(s.aliases))
+	for p := range s.aliases {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	res := make([]string, 0, len(paths))
+	for _, p := range paths {
+		if n := s.aliases[p]; n != s.pkgNames[p] {
+			res = append(res, fmt.Sprintf("%s %q", n, p))
+		} else {
+			res = append(res, strconv.Quote(p))
+		}
+	}
+	return res
+}
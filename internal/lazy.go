@@ -0,0 +1,32 @@
+package internal
+
+import (
+	"go/types"
+)
+
+// lazyType returns T when p is a thunk of type func() (T, error), which is
+// injected as a function resolving T from the container when called.
+func lazyType(p ParameterType) (ParameterType, bool) {
+	t := p.Type()
+	if t == nil {
+		return ParameterType{}, false
+	}
+	sig, ok := t.Underlying().(*types.Signature)
+	if !ok || sig.Params().Len() != 0 || sig.Results().Len() != 2 || !isError(sig.Results().At(1).Type()) {
+		return ParameterType{}, false
+	}
+	return ParameterType{DeclaredPackageName: p.DeclaredPackageName, Name: p.Name, typ: sig.Results().At(0).Type()}, true
+}
+
+// appendLazy binds v to a thunk calling the container method of d. The thunk
+// may be called after the caller's context is done, so a context aware
+// method is called with a background context.
+func (g *Generator) appendLazy(v string, p ParameterType, d Dependency, takesContext map[string]bool) {
+	args := ""
+	if takesContext[d.Method] {
+		args = g.contextPkg() + ".Background()"
+	}
+	g.Printf("%s := %s {\n", v, types.TypeString(p.Type().Underlying(), g.qualifier))
+	g.Printf("return d.%s(%s)\n", d.Method, args)
+	g.Printf("}\n")
+}
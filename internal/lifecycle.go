@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"go/types"
+)
+
+type closerKind int
+
+const (
+	noCloser closerKind = iota
+	// Close() error, i.e. io.Closer
+	plainCloser
+	// Close(context.Context) error
+	contextCloser
+	// Shutdown(context.Context) error
+	contextShutdowner
+)
+
+// closerOf reports how a component of type p is torn down.
+func closerOf(p ParameterType) closerKind {
+	t := p.Type()
+	if t == nil {
+		return noCloser
+	}
+	if sig := methodSignature(t, "Close"); sig != nil && returnsError(sig) {
+		switch {
+		case sig.Params().Len() == 0:
+			return plainCloser
+		case sig.Params().Len() == 1 && isContext(sig.Params().At(0).Type()):
+			return contextCloser
+		}
+	}
+	if sig := methodSignature(t, "Shutdown"); sig != nil && returnsError(sig) &&
+		sig.Params().Len() == 1 && isContext(sig.Params().At(0).Type()) {
+		return contextShutdowner
+	}
+	return noCloser
+}
+
+func methodSignature(t types.Type, name string) *types.Signature {
+	obj, _, _ := types.LookupFieldOrMethod(t, true, nil, name)
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return nil
+	}
+	sig, _ := fn.Type().(*types.Signature)
+	if sig == nil || sig.Variadic() {
+		return nil
+	}
+	return sig
+}
+
+func returnsError(sig *types.Signature) bool {
+	return sig.Results().Len() == 1 && isError(sig.Results().At(0).Type())
+}
+
+// isContext reports whether t is context.Context.
+func isContext(t types.Type) bool {
+	n, ok := types.Unalias(t).(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := n.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "context" && obj.Name() == "Context"
+}
+
+// closeCall renders the expression tearing down instance, or "" when the
+// component has nothing to close.
+func closeCall(kind closerKind, instance, ctx string) string {
+	switch kind {
+	case plainCloser:
+		return instance + ".Close()"
+	case contextCloser:
+		return instance + ".Close(" + ctx + ")"
+	case contextShutdowner:
+		return instance + ".Shutdown(" + ctx + ")"
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package internal
+
+// optionType returns the type of the options taken by the constructor of
+// the container it, e.g. ContainerOption.
+func optionType(it *InterfaceType) string {
+	return it.Name + "Option"
+}
+
+// optionFunc returns the name of the option pre-seeding the component m,
+// With<Method> unless the prefix is set by "+DICON option=<prefix>".
+func optionFunc(it *InterfaceType, m FuncType) string {
+	prefix := "With"
+	if it.Option != "" {
+		prefix = it.Option
+	}
+	return prefix + m.Name
+}
+
+// overridable returns the components which can be pre-seeded: the cached
+// ones, built by a constructor.
+func (it *InterfaceType) overridable() []FuncType {
+	var res []FuncType
+	for _, m := range it.components() {
+		if m.isConfig() || m.Scope == transientScope {
+			continue
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+// OptionFuncs returns the names of the options generated for it.
+func (it *InterfaceType) OptionFuncs() []string {
+	var res []string
+	for _, m := range it.overridable() {
+		res = append(res, optionFunc(it, m))
+	}
+	return res
+}
+
+func (g *Generator) appendOptions(it *InterfaceType) {
+	typ := optionType(it)
+	g.Printf("type %s func(*%s)\n", typ, g.structName)
+	g.Printf("\n")
+	for _, m := range it.overridable() {
+		field := fieldName(m.Name)
+		g.Printf("func %s(instance %s) %s {\n", optionFunc(it, m), g.typeName(m.ReturnTypes[0]), typ)
+		g.Printf("return func(d *%s) {\n", g.structName)
+		g.Printf("d.components.%s.instance, d.components.%s.done = instance, true\n", field, field)
+		g.Printf("}\n")
+		g.Printf("}\n")
+		g.Printf("\n")
+	}
+}
+
+// appendApplyOptions applies opts to the container held by v.
+func (g *Generator) appendApplyOptions(v, opts string) {
+	g.Printf("for _, opt := range %s {\n", opts)
+	g.Printf("opt(%s)\n", v)
+	g.Printf("}\n")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"go/ast"
 	"go/token"
+	"go/types"
 	"log"
 	"regexp"
 	"strings"
@@ -11,28 +12,104 @@ import (
 
 type ParameterType struct {
 	DeclaredPackageName string
-	src                 ast.Expr
+	// Name is the declared name of the parameter, if any.
+	Name string
+	src  ast.Expr
+	typ  types.Type
 }
 
-func NewParameterType(packageName string, expr ast.Expr) *ParameterType {
+func NewParameterType(packageName string, expr ast.Expr, typ types.Type) *ParameterType {
 	return &ParameterType{
 		DeclaredPackageName: packageName,
 		src:                 expr,
+		typ:                 typ,
 	}
 }
 
+// Type returns the type checked type of the parameter, or nil when the
+// parameter was built from syntax only.
+func (p *ParameterType) Type() types.Type {
+	if !isValidType(p.typ) {
+		return nil
+	}
+	return p.typ
+}
+
 func (p *ParameterType) ConvertName(packageName string) string {
+	if t := p.Type(); t != nil {
+		qualifier := func(pkg *types.Package) string {
+			if pkg.Name() == packageName {
+				return ""
+			}
+			return pkg.Name()
+		}
+		if s, ok := t.(*types.Slice); ok && p.variadic() {
+			return "..." + types.TypeString(s.Elem(), qualifier)
+		}
+		return types.TypeString(t, qualifier)
+	}
 	return convertName(p.DeclaredPackageName, packageName, p.src)
 }
 
+// variadic reports whether p is the last parameter of a variadic function,
+// declared as ...T, whose type is []T.
+func (p *ParameterType) variadic() bool {
+	_, ok := p.src.(*ast.Ellipsis)
+	return ok
+}
+
 func (p *ParameterType) SimpleName() string {
+	if t := p.Type(); t != nil {
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		switch n := t.(type) {
+		case *types.Named:
+			return n.Obj().Name()
+		case *types.Alias:
+			return n.Obj().Name()
+		case *types.Basic:
+			return n.Name()
+		case *types.Slice, *types.Array, *types.Map:
+			return types.TypeString(n, func(*types.Package) string { return "" })
+		}
+	}
 	switch n := p.src.(type) {
 	case *ast.SelectorExpr:
 		return n.Sel.Name
 	case *ast.Ident:
 		return n.Name
+	case *ast.StarExpr:
+		return (&ParameterType{src: n.X}).SimpleName()
+	}
+	return types.ExprString(p.src)
+}
+
+// Identical reports whether p and o denote the same type. The type checked
+// types are compared when both are available, otherwise it falls back to the
+// names written in the source.
+func (p *ParameterType) Identical(o ParameterType) bool {
+	if t1, t2 := p.Type(), o.Type(); t1 != nil && t2 != nil {
+		return types.Identical(t1, t2)
+	}
+	return p.key() == o.key()
+}
+
+// key identifies the type across packages; two types which share the same
+// name in different packages have different keys.
+func (p *ParameterType) key() string {
+	if t := p.Type(); t != nil {
+		return types.TypeString(t, nil)
+	}
+	return p.SimpleName()
+}
+
+func isValidType(t types.Type) bool {
+	if t == nil {
+		return false
 	}
-	panic("unreachable")
+	b, ok := t.(*types.Basic)
+	return !ok || b.Kind() != types.Invalid
 }
 
 func convertName(declared, packageName string, expr ast.Expr) string {
@@ -28,7 +28,7 @@ func Test_relativeSelectorName(t *testing.T) {
 
 	for _, c := range cases {
 		if r := relativeSelectorName(c.in.d, c.in.c, c.in.s); r != c.out {
-			t.Errorf(diff.CharacterDiff(r, c.out))
+			t.Error(diff.CharacterDiff(r, c.out))
 		}
 	}
 }
@@ -73,7 +73,7 @@ func TestParameterTypeConvertName(t *testing.T) {
 		}
 		p := &ParameterType{DeclaredPackageName: "pack", src: ast}
 		if act := p.ConvertName("current"); act != c.out {
-			t.Errorf(diff.CharacterDiff(act, c.out))
+			t.Error(diff.CharacterDiff(act, c.out))
 		}
 	}
 }
@@ -3,15 +3,20 @@ package internal
 import (
 	"fmt"
 	"go/ast"
-	"go/parser"
 	"go/token"
+	"go/types"
+	"path/filepath"
 	"strings"
 
-	"github.com/k0kubun/pp"
+	"golang.org/x/tools/go/packages"
 )
 
+const loadMode = packages.NeedName | packages.NeedFiles | packages.NeedSyntax |
+	packages.NeedTypes | packages.NeedTypesInfo | packages.NeedImports | packages.NeedDeps
+
 type PackageParser struct {
 	PackageName string
+	pkg         *packages.Package
 }
 
 type comments []comment
@@ -19,18 +24,145 @@ type comment string
 
 type InterfaceType struct {
 	PackageName    string
+	PackagePath    string
+	PackageDir     string
 	Comments       comments
 	Name           string
 	Funcs          []FuncType
 	DependPackages []Package
+	// Out, StructName, Constructor and Option are set by the options of the +DICON
+	// line, e.g. "+DICON name=appContainer constructor=NewAppContainer".
+	Out         string
+	StructName  string
+	Constructor string
+	// Option is the prefix of the options pre-seeding the components,
+	// e.g. "+DICON option=WithAPI" for WithAPIUserService.
+	Option string
+	// SetProviders are the constructors taken from the included provider
+	// sets.
+	SetProviders []FuncType
+
+	embeds []ast.Expr
+	// embedded are the interfaces embedded in it, directly or not.
+	embedded []*types.TypeName
 }
 
 type FuncType struct {
 	ArgumentTypes []ParameterType
 	ReturnTypes   []ParameterType
 	PackageName   string
+	PackagePath   string
 	Comments      comments
 	Name          string
+	FuncName      string
+	Scope         string
+	TakesContext  bool
+	Config        bool
+	Variadic      bool
+	Provider      *FuncType
+	Impl          *ParameterType
+	Bind          *ParameterType
+	Set           string
+	Dependencies  []Dependency
+	// Decorator is set on the functions annotated with +DICON:decorate,
+	// which are applied by ascending Order to the component Name.
+	Decorator  bool
+	Order      int
+	Decorators []FuncType
+}
+
+func (f *FuncType) constructorName() string {
+	if f.FuncName != "" {
+		return f.FuncName
+	}
+	return "New" + f.Name
+}
+
+// constructedType returns the type built for the container method f, which
+// is the concrete type bound by +DICON:bind if any.
+func (f FuncType) constructedType() ParameterType {
+	if f.Bind != nil {
+		return *f.Bind
+	}
+	return f.ReturnTypes[0]
+}
+
+// hasCleanup reports whether the constructor f returns (T, func(), error).
+func (f FuncType) hasCleanup() bool {
+	return len(f.ReturnTypes) == 3
+}
+
+// components returns the container methods which provide a component,
+// leaving out NewScope and Close, which the generated container implements.
+func (it *InterfaceType) components() []FuncType {
+	res := make([]FuncType, 0, len(it.Funcs))
+	for _, f := range it.Funcs {
+		if len(f.ReturnTypes) == 0 || it.isScopeMethod(f) || it.isCloseMethod(f) {
+			continue
+		}
+		res = append(res, f)
+	}
+	return res
+}
+
+// isScopeMethod reports whether f is `NewScope() <Container>`, which is
+// implemented by the generated container itself. A NewScope flattened from
+// an embedded container returns that container instead.
+func (it *InterfaceType) isScopeMethod(f FuncType) bool {
+	if f.Name != "NewScope" || len(f.ArgumentTypes) != 0 || len(f.ReturnTypes) != 1 {
+		return false
+	}
+	return f.ReturnTypes[0].SimpleName() == it.Name || it.isEmbedded(f.ReturnTypes[0])
+}
+
+// isEmbedded reports whether p is one of the interfaces embedded in it.
+func (it *InterfaceType) isEmbedded(p ParameterType) bool {
+	n, ok := types.Unalias(p.Type()).(*types.Named)
+	if !ok || n.Obj().Pkg() == nil {
+		return false
+	}
+	for _, e := range it.embedded {
+		if e.Name() == n.Obj().Name() && e.Pkg().Path() == n.Obj().Pkg().Path() {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeMethod returns the NewScope method of it, if declared.
+func (it *InterfaceType) scopeMethod() (FuncType, bool) {
+	for _, f := range it.Funcs {
+		if it.isScopeMethod(f) {
+			return f, true
+		}
+	}
+	return FuncType{}, false
+}
+
+func (it *InterfaceType) hasScopeMethod() bool {
+	_, ok := it.scopeMethod()
+	return ok
+}
+
+// isCloseMethod reports whether f is `Close(context.Context) error`, which
+// tears down the components built by the generated container.
+func (it *InterfaceType) isCloseMethod(f FuncType) bool {
+	return f.Name == "Close" && f.takesContext() && len(f.ReturnTypes) == 1 && isErrorType(f.ReturnTypes[0])
+}
+
+// takesContext reports whether the container method f is declared as
+// `Method(ctx context.Context)`.
+func (f FuncType) takesContext() bool {
+	return len(f.ArgumentTypes) == 1 && isContextType(f.ArgumentTypes[0])
+}
+
+func (it *InterfaceType) hasCloseMethod() bool {
+	for _, f := range it.Funcs {
+		if it.isCloseMethod(f) {
+			return true
+		}
+	}
+	return false
 }
 
 type Package struct {
@@ -38,38 +170,124 @@ type Package struct {
 	Path string
 }
 
-func NewPackageParser(pack string) *PackageParser {
-	return &PackageParser{
-		PackageName: pack,
+// LoadPackages loads and type checks the packages matched by patterns.
+// Type errors are tolerated, since a stale generated file must not prevent
+// dicon from regenerating it.
+func LoadPackages(patterns ...string) ([]*packages.Package, error) {
+	return loadPackagesIn("", patterns...)
+}
+
+// loadPackagesIn loads the packages matched by patterns from the module of
+// dir, or of the working directory when dir is empty.
+func loadPackagesIn(dir string, patterns ...string) ([]*packages.Package, error) {
+	pkgs, err := packages.Load(&packages.Config{Mode: loadMode, Dir: dir}, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range pkgs {
+		for _, e := range pkg.Errors {
+			if e.Kind != packages.TypeError {
+				return nil, e
+			}
+		}
 	}
+	return pkgs, nil
 }
 
-func (p *PackageParser) FindDicon(filenames []string) (*InterfaceType, error) {
-	result := make([]InterfaceType, 0, len(filenames))
-	for _, filename := range filenames {
-		its, err := findDicon(p.PackageName, filename, nil, "+DICON")
-		if err != nil {
-			return nil, err
+// ConstructorPackages returns the packages to search for constructors: the
+// loaded packages and the packages of the embedded interfaces, then the
+// packages declaring the types returned by the container methods, which are
+// only searched as a fallback.
+func ConstructorPackages(loaded []*packages.Package, it *InterfaceType) ([]*packages.Package, []*packages.Package) {
+	all := map[string]*packages.Package{}
+	packages.Visit(loaded, func(pkg *packages.Package) bool {
+		all[pkg.PkgPath] = pkg
+		return true
+	}, nil)
+
+	var targets, fallbacks []*packages.Package
+	seen := map[string]struct{}{}
+	add := func(res *[]*packages.Package, pkg *packages.Package) {
+		if _, ok := seen[pkg.PkgPath]; ok {
+			return
+		}
+		seen[pkg.PkgPath] = struct{}{}
+		*res = append(*res, pkg)
+	}
+	for _, pkg := range loaded {
+		add(&targets, pkg)
+	}
+	// methods of an embedded interface are built by the constructors
+	// declared along with it.
+	for _, f := range it.Funcs {
+		if pkg, ok := all[f.PackagePath]; ok && len(f.ReturnTypes) > 0 {
+			add(&targets, pkg)
+		}
+	}
+	for _, f := range it.Funcs {
+		if len(f.ReturnTypes) == 0 {
+			continue
 		}
-		if len(its) > 1 {
-			return nil, fmt.Errorf("DICON interface must be single, but %d", len(its))
-		} else if len(its) == 1 {
-			result = append(result, its[0])
+		t := f.constructedType()
+		if pkg, ok := all[declaringPackage(t.Type())]; ok {
+			add(&fallbacks, pkg)
 		}
 	}
+	return targets, fallbacks
+}
 
-	if len(result) == 0 {
-		return nil, nil
+func declaringPackage(t types.Type) string {
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	if n, ok := t.(interface{ Obj() *types.TypeName }); ok && n.Obj().Pkg() != nil {
+		return n.Obj().Pkg().Path()
 	}
+	return ""
+}
 
-	return &result[0], nil
+func NewPackageParser(pkg *packages.Package) *PackageParser {
+	return &PackageParser{
+		PackageName: pkg.Name,
+		pkg:         pkg,
+	}
 }
 
-func (p *PackageParser) FindConstructors(filenames []string, funcnames []string) ([]FuncType, error) {
+// FindDicons returns every interface annotated with +DICON in the package.
+func (p *PackageParser) FindDicons() ([]InterfaceType, error) {
+	var result []InterfaceType
+	for _, f := range p.pkg.Syntax {
+		its := findDicon(p.pkg, f, "+DICON")
+		for i := range its {
+			if err := checkContainerNames(&its[i]); err != nil {
+				return nil, err
+			}
+			if err := findProviders(p.pkg, f, &its[i]); err != nil {
+				return nil, err
+			}
+			if err := flattenEmbeds(p.pkg, &its[i], map[string]struct{}{}); err != nil {
+				return nil, err
+			}
+			if err := findSetProviders(p.pkg, f, &its[i]); err != nil {
+				return nil, err
+			}
+			if err := checkGeneratedNames(&its[i]); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, its...)
+	}
+	return result, nil
+}
+
+func (p *PackageParser) FindConstructors(targets []FuncType) ([]FuncType, error) {
 	var result []FuncType
 
-	for _, f := range filenames {
-		r, err := findConstructors(p.PackageName, f, nil, funcnames)
+	for _, f := range p.pkg.Syntax {
+		if isGeneratedByDicon(f) {
+			continue
+		}
+		r, err := findConstructors(p.pkg, f, targets)
 		if err != nil {
 			return nil, err
 		}
@@ -79,78 +297,187 @@ func (p *PackageParser) FindConstructors(filenames []string, funcnames []string)
 	return result, nil
 }
 
-func (p *PackageParser) FindDependencyInterfaces(filenames []string, targetNames []string) ([]InterfaceType, error) {
-	var result []InterfaceType
+// isGeneratedByDicon reports whether f is a container generated by dicon,
+// whose New<Container> must not be taken for a constructor.
+func isGeneratedByDicon(f *ast.File) bool {
+	if !ast.IsGenerated(f) {
+		return false
+	}
+	for _, c := range f.Comments {
+		if c.Pos() > f.Package {
+			break
+		}
+		if strings.HasPrefix(c.Text(), `Code generated by "dicon"`) {
+			return true
+		}
+	}
+	return false
+}
 
-	for _, f := range filenames {
-		r, err := parseDependencyFuncs(p.PackageName, targetNames, f, nil)
-		if err != nil {
-			return nil, err
+// ComponentTypeNames returns the names of the types returned by the container
+// methods, which are the interfaces to mock.
+func (it *InterfaceType) ComponentTypeNames() []string {
+	var res []string
+	for _, f := range it.components() {
+		if n := f.ReturnTypes[0].SimpleName(); !contains(n, res) {
+			res = append(res, n)
 		}
-		result = append(result, r...)
+	}
+	return res
+}
+
+func (p *PackageParser) FindDependencyInterfaces(targetNames []string) ([]InterfaceType, error) {
+	var result []InterfaceType
+
+	for _, f := range p.pkg.Syntax {
+		result = append(result, parseDependencyFuncs(p.pkg, targetNames, f)...)
 	}
 
 	return result, nil
 }
 
-func findConstructors(packageName string, from string, src interface{}, funcnames []string) ([]FuncType, error) {
-	f, err := parser.ParseFile(token.NewFileSet(), from, src, parser.ParseComments)
-	if err != nil {
-		return nil, err
-	}
+func findConstructors(pkg *packages.Package, f *ast.File, targets []FuncType) ([]FuncType, error) {
 	var funcs []FuncType
+	var err error
 
 	ast.Inspect(f, func(n ast.Node) bool {
 		fun, ok := n.(*ast.FuncDecl)
-		if !ok {
+		if !ok || fun.Recv != nil {
 			return true
 		}
-		if fun.Type.Results == nil || (len(fun.Type.Results.List) != 1 && len(fun.Type.Results.List) != 2) {
+		if fun.Type.Results == nil || len(fun.Type.Results.List) > 3 {
 			return true
 		}
-		resultType := fun.Type.Results.List[0]
-		for _, name := range funcnames {
-			s := fmt.Sprintf("New%s", name)
-			if s == fun.Name.Name && name == fmt.Sprintf("%v", resultType.Type) {
-				if len(fun.Type.Results.List) == 2 && fmt.Sprintf("%v", fun.Type.Results.List[1].Type) != "error" {
-					pp.Println(fun.Type.Results.List[1])
-					return true
-				}
-				args := make([]ParameterType, 0, len(fun.Type.Params.List))
-				for _, p := range fun.Type.Params.List {
-					args = append(args, *NewParameterType(packageName, p.Type))
+		annotated := FuncType{Comments: findComments(fun.Doc)}
+		if _, ok := annotated.Comments.annotation("decorate"); ok {
+			ds, e := decoratorFuncs(pkg, fun, annotated.Comments, targets)
+			if e != nil {
+				if err == nil {
+					err = e
 				}
-				returns := []ParameterType{*NewParameterType(packageName, resultType.Type)}
-				if len(fun.Type.Results.List) == 2 {
-					eType := fun.Type.Results.List[1]
-					returns = append(returns, ParameterType{DeclaredPackageName: packageName, src: eType.Type})
+				return false
+			}
+			funcs = append(funcs, ds...)
+			return true
+		}
+		for _, target := range targets {
+			if len(target.ReturnTypes) == 0 {
+				continue
+			}
+			provides := annotated.provides(target.Name)
+			if !provides && !isConstructorName(fun.Name.Name, target) {
+				continue
+			}
+			returns := fieldTypes(pkg.Name, pkg.TypesInfo, fun.Type.Results)
+			if len(returns) != len(fun.Type.Results.List) {
+				continue
+			}
+			if !provides && !returns[0].Identical(target.constructedType()) {
+				continue
+			}
+			e := checkConstructorResults(pkg.Name, fun.Name.Name, returns)
+			if e == nil && (provides || target.Bind != nil) {
+				returns, e = provideAs(fun.Name.Name, returns, target)
+			}
+			if e != nil {
+				if err == nil {
+					err = e
 				}
-
-				funcs = append(funcs, FuncType{
-					ArgumentTypes: args,
-					ReturnTypes:   returns,
-					Name:          name,
-					PackageName:   packageName,
-				})
+				return false
 			}
+
+			funcs = append(funcs, FuncType{
+				ArgumentTypes: fieldTypes(pkg.Name, pkg.TypesInfo, fun.Type.Params),
+				ReturnTypes:   returns,
+				Name:          target.Name,
+				FuncName:      fun.Name.Name,
+				PackageName:   pkg.Name,
+				PackagePath:   pkg.PkgPath,
+				Comments:      annotated.Comments,
+				Variadic:      isVariadic(fun.Type),
+			})
 		}
 		return true
 	})
 
-	return funcs, nil
+	return funcs, err
 }
 
-func findDicon(packageName string, from string, src interface{}, annotation string) ([]InterfaceType, error) {
-	f, err := parser.ParseFile(token.NewFileSet(), from, src, parser.ParseComments)
-	if err != nil {
-		return nil, err
+// isVariadic reports whether the last parameter of ft is declared as ...T.
+func isVariadic(ft *ast.FuncType) bool {
+	if ft.Params == nil || len(ft.Params.List) == 0 {
+		return false
 	}
+	_, ok := ft.Params.List[len(ft.Params.List)-1].Type.(*ast.Ellipsis)
+	return ok
+}
+
+// isConstructorName reports whether name is New<Method> or New<ReturnType>
+// for the container method target.
+func isConstructorName(name string, target FuncType) bool {
+	t := target.constructedType()
+	return name == fmt.Sprintf("New%s", target.Name) || name == fmt.Sprintf("New%s", t.SimpleName())
+}
 
+// checkConstructorResults accepts the constructors returning T, (T, error)
+// or (T, func(), error), where the func() cleans up the instance.
+func checkConstructorResults(pkgName, name string, returns []ParameterType) error {
+	switch len(returns) {
+	case 2:
+		if !isErrorType(returns[1]) {
+			return fmt.Errorf("%s must return (%s, error), but the second result is %s",
+				name, returns[0].ConvertName(pkgName), returns[1].ConvertName(pkgName))
+		}
+	case 3:
+		if !isCleanupType(returns[1]) {
+			return fmt.Errorf("%s must return (%s, func(), error), but the second result is %s",
+				name, returns[0].ConvertName(pkgName), returns[1].ConvertName(pkgName))
+		}
+		if !isErrorType(returns[2]) {
+			return fmt.Errorf("%s must return (%s, func(), error), but the third result is %s",
+				name, returns[0].ConvertName(pkgName), returns[2].ConvertName(pkgName))
+		}
+	}
+	return nil
+}
+
+func isCleanupType(p ParameterType) bool {
+	if t := p.Type(); t != nil {
+		sig, ok := t.Underlying().(*types.Signature)
+		return ok && sig.Params().Len() == 0 && sig.Results().Len() == 0
+	}
+	ft, ok := p.src.(*ast.FuncType)
+	return ok && (ft.Params == nil || len(ft.Params.List) == 0) && (ft.Results == nil || len(ft.Results.List) == 0)
+}
+
+func isContextType(p ParameterType) bool {
+	if t := p.Type(); t != nil {
+		return isContext(t)
+	}
+	sel, ok := p.src.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && x.Name == "context" && sel.Sel.Name == "Context"
+}
+
+func isErrorType(p ParameterType) bool {
+	if t := p.Type(); t != nil {
+		return isError(t)
+	}
+	return p.SimpleName() == "error"
+}
+
+func isError(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
+
+func findDicon(pkg *packages.Package, f *ast.File, annotation string) []InterfaceType {
 	deps := getDependencies(f)
 
 	var its []InterfaceType
 
-	pkg := f.Name.Name
 	ast.Inspect(f, func(n ast.Node) bool {
 		g, ok := n.(*ast.GenDecl)
 		if !ok || g.Tok != token.TYPE {
@@ -160,19 +487,36 @@ func findDicon(packageName string, from string, src interface{}, annotation stri
 		if !isAnnotated(comments, annotation) {
 			return true
 		}
-		it, ok := findInterface(packageName, g.Specs)
+		it, ok := findInterface(pkg.Name, pkg.TypesInfo, g.Specs)
 		if !ok {
 			return true
 		}
 		it.Comments = comments
-		it.PackageName = pkg
+		it.PackageName = f.Name.Name
+		it.PackagePath = pkg.PkgPath
+		it.PackageDir = packageDir(pkg)
 		it.DependPackages = deps
+		opts := containerOptions(comments, annotation)
+		it.Out = strings.TrimSuffix(opts["out"], ".go")
+		it.StructName = opts["name"]
+		it.Constructor = opts["constructor"]
+		it.Option = opts["option"]
 		its = append(its, *it)
 
 		return true
 	})
 
-	return its, nil
+	return its
+}
+
+func packageDir(pkg *packages.Package) string {
+	if pkg.Dir != "" {
+		return pkg.Dir
+	}
+	if len(pkg.GoFiles) > 0 {
+		return filepath.Dir(pkg.GoFiles[0])
+	}
+	return pkg.Name
 }
 
 func findComments(cs *ast.CommentGroup) comments {
@@ -188,15 +532,95 @@ func findComments(cs *ast.CommentGroup) comments {
 }
 
 func isAnnotated(cs comments, annotation string) bool {
+	_, ok := annotationLine(cs, annotation)
+	return ok
+}
+
+// annotationLine returns the comment which is annotation itself or starts
+// with annotation followed by options, e.g. "+DICON out=api_gen".
+func annotationLine(cs comments, annotation string) (string, bool) {
 	for _, c := range cs {
-		if strings.HasPrefix(string(c), annotation) {
-			return true
+		s := string(c)
+		if s == annotation || strings.HasPrefix(s, annotation+" ") {
+			return s, true
 		}
 	}
-	return false
+	return "", false
 }
 
-func findInterface(packageName string, specs []ast.Spec) (*InterfaceType, bool) {
+// containerOptionKeys are the options of the +DICON line.
+var containerOptionKeys = []string{"name", "constructor", "out", "option"}
+
+func checkContainerNames(it *InterfaceType) error {
+	line, _ := annotationLine(it.Comments, "+DICON")
+	for _, opt := range strings.Fields(strings.TrimPrefix(line, "+DICON")) {
+		if i := strings.Index(opt, "="); i <= 0 || !contains(opt[:i], containerOptionKeys) {
+			return fmt.Errorf("%s: unknown option %s: must be one of %s=", it.Name, opt, strings.Join(containerOptionKeys, "=, "))
+		}
+	}
+	for _, opt := range []struct{ key, name string }{
+		{"name", it.StructName},
+		{"constructor", it.Constructor},
+		{"option", it.Option},
+	} {
+		if opt.name != "" && !token.IsIdentifier(opt.name) {
+			return fmt.Errorf("%s: %s=%s is not a valid identifier", it.Name, opt.key, opt.name)
+		}
+	}
+	if it.StructName == it.Name {
+		return fmt.Errorf("%s: name must differ from the interface name", it.Name)
+	}
+	if it.StructName != "" && it.StructName == it.Constructor {
+		return fmt.Errorf("%s: name and constructor must differ, but both are %s", it.Name, it.StructName)
+	}
+	return nil
+}
+
+// checkGeneratedNames rejects the containers declaring two identifiers of
+// the same name, among the interface, the container struct, its constructor
+// and its options.
+func checkGeneratedNames(it *InterfaceType) error {
+	declared := map[string]string{it.Name: "interface"}
+	check := func(kind, name string) error {
+		if other, ok := declared[name]; ok {
+			return fmt.Errorf("%s: the %s and the %s are both named %s", it.Name, other, kind, name)
+		}
+		declared[name] = kind
+		return nil
+	}
+	if err := check("container struct", containerStructName(it)); err != nil {
+		return err
+	}
+	if err := check("constructor", containerConstructor(it)); err != nil {
+		return err
+	}
+	if err := check("option type", optionType(it)); err != nil {
+		return err
+	}
+	for _, fn := range it.OptionFuncs() {
+		if err := check("option", fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// containerOptions returns the key=value options of the +DICON line.
+func containerOptions(cs comments, annotation string) map[string]string {
+	res := map[string]string{}
+	line, ok := annotationLine(cs, annotation)
+	if !ok {
+		return res
+	}
+	for _, opt := range strings.Fields(strings.TrimPrefix(line, annotation)) {
+		if i := strings.Index(opt, "="); i > 0 {
+			res[opt[:i]] = opt[i+1:]
+		}
+	}
+	return res
+}
+
+func findInterface(packageName string, info *types.Info, specs []ast.Spec) (*InterfaceType, bool) {
 	it := &InterfaceType{}
 	var funcs []FuncType
 
@@ -210,33 +634,18 @@ func findInterface(packageName string, specs []ast.Spec) (*InterfaceType, bool)
 		for _, m := range s.Methods.List {
 			f, ok := m.Type.(*ast.FuncType)
 			if !ok {
+				if len(m.Names) == 0 {
+					it.embeds = append(it.embeds, m.Type)
+				}
 				continue
 			}
 			ft := &FuncType{}
-
-			var params []ParameterType
-			if f.Params != nil {
-				for _, p := range f.Params.List {
-					for range p.Names {
-						params = append(params, *NewParameterType(packageName, p.Type))
-					}
-				}
-			}
-			ft.ArgumentTypes = params
-
-			var returns []ParameterType
-			if f.Results != nil {
-				for _, r := range f.Results.List {
-					if len(r.Names) == 0 {
-						returns = append(returns, *NewParameterType(packageName, r.Type))
-					} else {
-						for range r.Names {
-							returns = append(returns, *NewParameterType(packageName, r.Type))
-						}
-					}
-				}
+			ft.ArgumentTypes = fieldTypes(packageName, info, f.Params)
+			ft.ReturnTypes = fieldTypes(packageName, info, f.Results)
+			ft.Comments = append(findComments(m.Doc), findComments(m.Comment)...)
+			if a, ok := ft.Comments.annotation("scope"); ok {
+				ft.Scope = a.Value
 			}
-			ft.ReturnTypes = returns
 
 			for _, n := range m.Names {
 				ft.Name = n.Name
@@ -249,12 +658,38 @@ func findInterface(packageName string, specs []ast.Spec) (*InterfaceType, bool)
 	return it, true
 }
 
-func parseDependencyFuncs(packagename string, targetNames []string, from string, src interface{}) ([]InterfaceType, error) {
-	var res []InterfaceType
-	f, err := parser.ParseFile(token.NewFileSet(), from, src, parser.ParseComments)
-	if err != nil {
-		return nil, err
+// fieldTypes expands a field list into one ParameterType per value, so
+// `a, b int` yields two entries and an unnamed field yields one.
+func fieldTypes(packageName string, info *types.Info, fields *ast.FieldList) []ParameterType {
+	res := []ParameterType{}
+	if fields == nil {
+		return res
 	}
+	for _, field := range fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			p := NewParameterType(packageName, field.Type, typeOf(info, field.Type))
+			if i < len(field.Names) {
+				p.Name = field.Names[i].Name
+			}
+			res = append(res, *p)
+		}
+	}
+	return res
+}
+
+func typeOf(info *types.Info, expr ast.Expr) types.Type {
+	if info == nil {
+		return nil
+	}
+	return info.TypeOf(expr)
+}
+
+func parseDependencyFuncs(pkg *packages.Package, targetNames []string, f *ast.File) []InterfaceType {
+	var res []InterfaceType
 
 	deps := getDependencies(f)
 
@@ -263,7 +698,7 @@ func parseDependencyFuncs(packagename string, targetNames []string, from string,
 		if !ok || g.Tok != token.TYPE {
 			return true
 		}
-		it, ok := findInterface(packagename, g.Specs)
+		it, ok := findInterface(pkg.Name, pkg.TypesInfo, g.Specs)
 		if !ok || !contains(it.Name, targetNames) {
 			return true
 		}
@@ -271,7 +706,7 @@ func parseDependencyFuncs(packagename string, targetNames []string, from string,
 		res = append(res, *it)
 		return true
 	})
-	return res, nil
+	return res
 }
 
 func contains(s string, source []string) bool {
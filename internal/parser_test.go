@@ -4,7 +4,11 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"reflect"
+	"strings"
 	"testing"
+
+	"golang.org/x/tools/go/packages"
 )
 
 var TEST_FILE1 = `
@@ -22,10 +26,8 @@ type Ex2 interface {
 `
 
 func TestPackageParser_findDicon(t *testing.T) {
-	its, err := findDicon("main", "/tmp/tmp.go", TEST_FILE1, "+DICON")
-	if err != nil {
-		t.Error(err)
-	}
+	pkg := parseTestPackage(t, "main", TEST_FILE1)
+	its := findDicon(pkg, pkg.Syntax[0], "+DICON")
 	if len(its) != 1 {
 		t.Errorf("must 1 interface but %d\n", len(its))
 	}
@@ -68,6 +70,10 @@ func TestPackageParser_findDicon(t *testing.T) {
 var TEST_COMPONENT = `
 package di
 
+type Dependency interface {
+	Run() error
+}
+
 type SampleComponent interface {
 	Exec() error
 }
@@ -88,18 +94,19 @@ func (s *sampleComponent) Exec() error {
 `
 
 func TestPackageParser_FindConstructors(t *testing.T) {
-	fs, _ := findConstructors("test", "/tmp/tmp.go", TEST_COMPONENT, []string{"SampleComponent"})
+	pkg := parseTestPackage(t, "test", TEST_COMPONENT)
+	fs, _ := findConstructors(pkg, pkg.Syntax[0], targetFuncs(t, pkg, "SampleComponent"))
 	if len(fs) != 1 {
 		t.Fatalf("must be 1")
 	}
 
 	fun := fs[0]
 
-	if len(fun.ReturnTypes) != 1 || fun.ReturnTypes[0].ConvertName("test") != "SampleComponent" {
+	if len(fun.ReturnTypes) != 1 || fun.ReturnTypes[0].ConvertName("di") != "SampleComponent" {
 		t.Errorf("return type: %v wrong", fun.ReturnTypes)
 	}
 
-	if len(fun.ArgumentTypes) != 1 || fun.ArgumentTypes[0].ConvertName("test") != "Dependency" {
+	if len(fun.ArgumentTypes) != 1 || fun.ArgumentTypes[0].ConvertName("di") != "Dependency" {
 		t.Errorf("arg type: %v wrong", fun.ArgumentTypes)
 	}
 
@@ -107,14 +114,18 @@ func TestPackageParser_FindConstructors(t *testing.T) {
 		t.Errorf("func name is SampleComponent but %s", fun.Name)
 	}
 
-	if fun.PackageName != "test" {
-		t.Errorf("package name is test but %s", fun.PackageName)
+	if fun.PackageName != "di" {
+		t.Errorf("package name is di but %s", fun.PackageName)
 	}
 }
 
 var TEST_COMPONENT_ERRORS = `
 package di
 
+type Dependency interface {
+	Run() error
+}
+
 type SampleComponent interface {
 	Exec() error
 }
@@ -135,7 +146,8 @@ func (s *sampleComponent) Exec() error {
 `
 
 func TestPackageParser_FindConstructorsErrors(t *testing.T) {
-	fs, _ := findConstructors("test", "/tmp/tmp.go", TEST_COMPONENT_ERRORS, []string{"SampleComponent"})
+	pkg := parseTestPackage(t, "test", TEST_COMPONENT_ERRORS)
+	fs, _ := findConstructors(pkg, pkg.Syntax[0], targetFuncs(t, pkg, "SampleComponent"))
 	if len(fs) != 1 {
 		t.Fatalf("must be 1, but %d", len(fs))
 	}
@@ -154,8 +166,8 @@ func TestPackageParser_FindConstructorsErrors(t *testing.T) {
 		t.Errorf("func name is SampleComponent but %s", fun.Name)
 	}
 
-	if fun.PackageName != "test" {
-		t.Errorf("package name is test but %s", fun.PackageName)
+	if fun.PackageName != "di" {
+		t.Errorf("package name is di but %s", fun.PackageName)
 	}
 }
 
@@ -177,7 +189,8 @@ func (*dependency) Run() error {
 `
 
 func TestPackageParer_parseDependencyFuncs(t *testing.T) {
-	ds, _ := parseDependencyFuncs("test", []string{"Dependency"}, "/tmp/tmp.go", TEST_DEPENDENCY)
+	pkg := parseTestPackage(t, "test", TEST_DEPENDENCY)
+	ds := parseDependencyFuncs(pkg, []string{"Dependency"}, pkg.Syntax[0])
 	if len(ds) != 1 {
 		t.Fatalf("dependency function length myst be 1 but %d", len(ds))
 	}
@@ -224,12 +237,12 @@ type A interface {
 					{
 						Name: "F",
 						ArgumentTypes: []ParameterType{
-							{"", ast.NewIdent("int")},
-							{"", ast.NewIdent("int")},
+							{src: ast.NewIdent("int")},
+							{src: ast.NewIdent("int")},
 						},
 						ReturnTypes: []ParameterType{
-							{"", ast.NewIdent("int")},
-							{"", ast.NewIdent("error")},
+							{src: ast.NewIdent("int")},
+							{src: ast.NewIdent("error")},
 						},
 					},
 				},
@@ -249,10 +262,10 @@ type B interface {
 					{
 						Name: "F",
 						ArgumentTypes: []ParameterType{
-							{"", ast.NewIdent("int")},
+							{src: ast.NewIdent("int")},
 						},
 						ReturnTypes: []ParameterType{
-							{"", ast.NewIdent("error")},
+							{src: ast.NewIdent("error")},
 						},
 					},
 				},
@@ -273,8 +286,8 @@ type C interface {
 						Name:          "F",
 						ArgumentTypes: []ParameterType{},
 						ReturnTypes: []ParameterType{
-							{"", ast.NewIdent("int")},
-							{"", ast.NewIdent("int")},
+							{src: ast.NewIdent("int")},
+							{src: ast.NewIdent("int")},
 						},
 					},
 				},
@@ -283,7 +296,7 @@ type C interface {
 	}
 
 	for _, tc := range ts {
-		got, ok := findInterface(tc.packageName, tc.specs)
+		got, ok := findInterface(tc.packageName, nil, tc.specs)
 		if ok != (tc.expected != nil) {
 			t.Errorf("unexpected result. expected: %v, but got: %v", tc.expected, got)
 			continue
@@ -332,3 +345,623 @@ type C interface {
 		}
 	}
 }
+
+var TEST_REPOSITORY_OTHER = `
+package other
+
+type Repository interface {
+	Find() error
+}
+`
+
+var TEST_REPOSITORY = `
+package di
+
+import "other"
+
+type Repository interface {
+	Save() error
+}
+
+// +DICON
+type Container interface {
+	Repository() (Repository, error)
+}
+
+func NewRepository() (other.Repository, error) {
+	return nil, nil
+}
+`
+
+func TestPackageParser_FindConstructorsTypeIdentity(t *testing.T) {
+	other := parseTestPackage(t, "other", TEST_REPOSITORY_OTHER)
+	pkg := parseTestPackage(t, "di", TEST_REPOSITORY, other)
+
+	its := findDicon(pkg, pkg.Syntax[0], "+DICON")
+	if len(its) != 1 {
+		t.Fatalf("must be 1 interface but %d", len(its))
+	}
+	if got := its[0].Funcs[0].ReturnTypes[0].ConvertName("di"); got != "Repository" {
+		t.Errorf("return type must be Repository but %s", got)
+	}
+
+	fs, err := findConstructors(pkg, pkg.Syntax[0], its[0].Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 0 {
+		t.Errorf("constructor returning other.Repository must not match, but got %d", len(fs))
+	}
+}
+
+func targetFuncs(t *testing.T, pkg *packages.Package, names ...string) []FuncType {
+	t.Helper()
+	var funcs []FuncType
+	for _, name := range names {
+		obj := pkg.Types.Scope().Lookup(name)
+		if obj == nil {
+			t.Fatalf("%s is not declared", name)
+		}
+		funcs = append(funcs, FuncType{
+			Name:        name,
+			ReturnTypes: []ParameterType{{src: ast.NewIdent(name), typ: obj.Type()}},
+		})
+	}
+	return funcs
+}
+
+var TEST_COMPONENT_CLEANUP = `
+package di
+
+type Pool interface{}
+
+type Cache interface{}
+
+func NewPool() (Pool, func(), error) {
+	return nil, func() {}, nil
+}
+
+func NewCache() (Cache, func() error, error) {
+	return nil, nil, nil
+}
+`
+
+func TestPackageParser_FindConstructorsCleanup(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_COMPONENT_CLEANUP)
+	fs, err := findConstructors(pkg, pkg.Syntax[0], targetFuncs(t, pkg, "Pool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 || !fs[0].hasCleanup() {
+		t.Fatalf("NewPool must be found with its cleanup: %v", fs)
+	}
+
+	_, err = findConstructors(pkg, pkg.Syntax[0], targetFuncs(t, pkg, "Cache"))
+	if err == nil || err.Error() != "NewCache must return (Cache, func(), error), but the second result is func() error" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+var TEST_PROVIDE = `
+package di
+
+import (
+	"strings"
+)
+
+type Repository interface{}
+
+type Other struct{}
+
+// +DICON
+type Container interface {
+	Repository() (Repository, error)
+	// +DICON:provider=strings.NewReader
+	Reader() (*strings.Reader, error)
+}
+
+// +DICON:provide Repository
+func openRepository() (Other, error) {
+	return Other{}, nil
+}
+`
+
+func TestPackageParser_FindConstructorsProvide(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_PROVIDE)
+	p := NewPackageParser(pkg)
+	its, err := p.FindDicons()
+	if err != nil || len(its) != 1 {
+		t.Fatalf("+DICON not found: %v", err)
+	}
+	it := its[0]
+	r := it.Funcs[1].Provider
+	if r == nil || r.FuncName != "NewReader" || r.PackagePath != "strings" || r.ArgumentTypes[0].Name != "s" {
+		t.Errorf("Reader must be provided by strings.NewReader: %+v", r)
+	}
+
+	// Other is assignable to the empty Repository interface
+	fs, err := p.FindConstructors(it.Funcs[:1])
+	if err != nil || len(fs) != 1 || fs[0].FuncName != "openRepository" {
+		t.Errorf("openRepository must provide Repository: %v, %v", fs, err)
+	}
+
+	it.Funcs[0].ReturnTypes = targetFuncs(t, pkg, "Container")[0].ReturnTypes
+	it.Funcs[0].Name = "Repository"
+	if _, err := p.FindConstructors(it.Funcs[:1]); err == nil ||
+		err.Error() != "openRepository provides 'Repository', but returns Other which is not assignable to Container" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	pkg = parseTestPackage(t, "di", strings.Replace(TEST_PROVIDE, "provider=strings.", "provider=bytes.", 1))
+	if _, err := NewPackageParser(pkg).FindDicons(); err == nil || err.Error() != "provider of 'Reader': package 'bytes' of 'bytes.NewReader' is not imported" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+var TEST_IMPL = `
+package di
+
+type UserService interface {
+	Exec() error
+}
+
+type userService struct {
+	Name string
+}
+
+type userID int
+
+// +DICON
+type Container interface {
+	// +DICON:impl userService
+	UserService() (UserService, error)
+}
+`
+
+func TestPackageParser_FindDiconImpl(t *testing.T) {
+	for _, c := range []struct {
+		impl string
+		err  string
+	}{
+		{"userService", "impl userService of 'UserService' is not assignable to UserService"},
+		{"userID", "impl userID of 'UserService' must be a struct"},
+		{"UserService2", "impl of 'UserService': 'UserService2' not found"},
+	} {
+		pkg := parseTestPackage(t, "di", strings.Replace(TEST_IMPL, "impl userService", "impl "+c.impl, 1))
+		if _, err := NewPackageParser(pkg).FindDicons(); err == nil || err.Error() != c.err {
+			t.Errorf("%s: unexpected error: %v", c.impl, err)
+		}
+	}
+}
+
+var TEST_IMPL_OTHER = `
+package other
+
+type userService struct{}
+
+func (userService) Exec() error { return nil }
+`
+
+var TEST_IMPL_UNEXPORTED = `
+package di
+
+import "other"
+
+type UserService interface {
+	Exec() error
+}
+
+// +DICON
+type Container interface {
+	// +DICON:impl other.userService
+	UserService() (UserService, error)
+}
+`
+
+func TestPackageParser_FindDiconImplUnexported(t *testing.T) {
+	other := parseTestPackage(t, "other", TEST_IMPL_OTHER)
+	pkg := parseTestPackage(t, "di", TEST_IMPL_UNEXPORTED, other)
+	if _, err := NewPackageParser(pkg).FindDicons(); err == nil ||
+		err.Error() != "impl of 'UserService': 'other.userService' is not exported" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+var TEST_BIND = `
+package di
+
+type UserRepository interface {
+	Find() error
+}
+
+type PostgresUserRepo struct{}
+
+func (*PostgresUserRepo) Find() error { return nil }
+
+// +DICON
+type Container interface {
+	// +DICON:bind=*PostgresUserRepo
+	UserRepository() (UserRepository, error)
+}
+
+func NewPostgresUserRepo() (*PostgresUserRepo, error) {
+	return nil, nil
+}
+`
+
+func TestPackageParser_FindConstructorsBind(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_BIND)
+	p := NewPackageParser(pkg)
+	its, err := p.FindDicons()
+	if err != nil || len(its) != 1 {
+		t.Fatalf("+DICON not found: %v", err)
+	}
+	it := its[0]
+	fs, err := p.FindConstructors(it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 || fs[0].FuncName != "NewPostgresUserRepo" || fs[0].ReturnTypes[0].SimpleName() != "UserRepository" {
+		t.Errorf("UserRepository must be built by NewPostgresUserRepo: %v", fs)
+	}
+
+	pkg = parseTestPackage(t, "di", strings.Replace(TEST_BIND, "bind=*", "bind=", 1))
+	if _, err := NewPackageParser(pkg).FindDicons(); err == nil ||
+		err.Error() != "bind of 'UserRepository': PostgresUserRepo does not implement UserRepository" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+var TEST_MULTIPLE_DICON = `
+package di
+
+type Server interface{}
+
+type Worker interface{}
+
+// +DICON name=apiContainer constructor=NewAPI
+type APIContainer interface {
+	Server() (Server, error)
+}
+
+// +DICON out=worker_gen.go
+type WorkerContainer interface {
+	Worker() (Worker, error)
+}
+
+// +DICONTAINER is not an annotation.
+type NotContainer interface {
+	Worker() (Worker, error)
+}
+`
+
+func TestPackageParser_FindDicons(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_MULTIPLE_DICON)
+	its, err := NewPackageParser(pkg).FindDicons()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(its) != 2 {
+		t.Fatalf("must be 2 containers but %d", len(its))
+	}
+	if its[0].Name != "APIContainer" || its[0].Out != "" || its[0].StructName != "apiContainer" || its[0].Constructor != "NewAPI" {
+		t.Errorf("unexpected container: %+v", its[0])
+	}
+	if its[1].Name != "WorkerContainer" || its[1].Out != "worker_gen" {
+		t.Errorf("unexpected container: %s, out=%s", its[1].Name, its[1].Out)
+	}
+}
+
+func TestPackageParser_FindDiconsInvalidNames(t *testing.T) {
+	for _, c := range []struct {
+		opts string
+		err  string
+	}{
+		{"name=api-container", "APIContainer: name=api-container is not a valid identifier"},
+		{"constructor=1New", "APIContainer: constructor=1New is not a valid identifier"},
+		{"name=NewAPI constructor=NewAPI", "APIContainer: name and constructor must differ, but both are NewAPI"},
+		{"name=APIContainer", "APIContainer: name must differ from the interface name"},
+		{"name=apiContainer constuctor=NewAPI", "APIContainer: unknown option constuctor=NewAPI: must be one of name=, constructor=, out=, option="},
+		{"name=apiContainer internal", "APIContainer: unknown option internal: must be one of name=, constructor=, out=, option="},
+		{"constructor=APIContainer", "APIContainer: the interface and the constructor are both named APIContainer"},
+		{"constructor=APIContainerOption", "APIContainer: the constructor and the option type are both named APIContainerOption"},
+		{"constructor=WithServer", "APIContainer: the constructor and the option are both named WithServer"},
+		{"name=WithServer", "APIContainer: the container struct and the option are both named WithServer"},
+	} {
+		pkg := parseTestPackage(t, "di", strings.Replace(TEST_MULTIPLE_DICON, "name=apiContainer constructor=NewAPI", c.opts, 1))
+		if _, err := NewPackageParser(pkg).FindDicons(); err == nil || err.Error() != c.err {
+			t.Errorf("%s: unexpected error: %v", c.opts, err)
+		}
+	}
+}
+
+var TEST_EMBED_CORE = `
+package core
+
+type Logger interface{}
+
+type DB interface{}
+
+type BaseContainer interface {
+	DB() (DB, error)
+}
+
+type CoreContainer interface {
+	BaseContainer
+	// +DICON:scope=transient
+	Logger() (Logger, error)
+}
+`
+
+var TEST_EMBED_API = `
+package api
+
+import "example.com/core"
+
+type Server interface{}
+
+// +DICON
+type APIContainer interface {
+	core.CoreContainer
+	Server() (Server, error)
+	// +DICON:scope=request
+	Logger() (core.Logger, error)
+}
+`
+
+func TestPackageParser_FindDiconsEmbedded(t *testing.T) {
+	corePkg := parseTestPackage(t, "example.com/core", TEST_EMBED_CORE)
+	pkg := parseTestPackage(t, "example.com/api", TEST_EMBED_API, corePkg)
+
+	its, err := NewPackageParser(pkg).FindDicons()
+	if err != nil || len(its) != 1 {
+		t.Fatalf("+DICON not found: %v", err)
+	}
+	got := map[string]FuncType{}
+	for _, f := range its[0].Funcs {
+		got[f.Name] = f
+	}
+	if len(got) != 3 || len(its[0].Funcs) != 3 {
+		t.Fatalf("must be Server, Logger and DB but %v", its[0].Funcs)
+	}
+	if got["Logger"].Scope != "request" {
+		t.Errorf("Logger declared by APIContainer must win but %s", got["Logger"].Scope)
+	}
+	if db := got["DB"]; db.PackagePath != "example.com/core" || db.ReturnTypes[0].ConvertName("api") != "core.DB" {
+		t.Errorf("DB must be flattened from core: %+v", db)
+	}
+
+	if pkgs, fallbacks := ConstructorPackages([]*packages.Package{pkg}, &its[0]); len(pkgs) != 2 || pkgs[1] != corePkg || len(fallbacks) != 0 {
+		t.Errorf("constructors must be looked up in core: %v, %v", pkgs, fallbacks)
+	}
+}
+
+func TestPackageParser_FindDiconsEmbeddedError(t *testing.T) {
+	corePkg := parseTestPackage(t, "example.com/core", TEST_EMBED_CORE)
+	pkg := parseTestPackage(t, "example.com/api", strings.Replace(TEST_EMBED_API, "core.CoreContainer", "error", 1), corePkg)
+
+	if _, err := NewPackageParser(pkg).FindDicons(); err == nil ||
+		err.Error() != "APIContainer: embedded error must be an interface declared in a package" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+var TEST_SET_LIBRARY = `
+package logging
+
+type Logger struct{}
+
+type Metrics struct{}
+
+func NewLogger() *Logger {
+	return &Logger{}
+}
+
+func OpenMetrics(l *Logger) (*Metrics, error) {
+	return &Metrics{}, nil
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{}
+}
+
+// +DICON:set
+var MetricsSet = []interface{}{OpenMetrics}
+
+// +DICON:set
+var ProviderSet = []interface{}{NewLogger, MetricsSet}
+
+// +DICON:set
+var PrivateSet = []interface{}{newMetrics}
+
+var NotASet = []interface{}{NewLogger}
+`
+
+var TEST_SET_CONTAINER = `
+package api
+
+import "example.com/logging"
+
+// +DICON
+// +DICON:include logging.ProviderSet
+type Container interface {
+	Logger() (*logging.Logger, error)
+	Metrics() (*logging.Metrics, error)
+}
+`
+
+func TestPackageParser_FindDiconsSet(t *testing.T) {
+	lib := parseTestPackage(t, "example.com/logging", TEST_SET_LIBRARY)
+	pkg := parseTestPackage(t, "example.com/api", TEST_SET_CONTAINER, lib)
+
+	its, err := NewPackageParser(pkg).FindDicons()
+	if err != nil || len(its) != 1 {
+		t.Fatalf("+DICON not found: %v", err)
+	}
+	fs, err := ResolveDependencies(&its[0], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fs {
+		switch f.Name {
+		case "Logger":
+			if f.FuncName != "NewLogger" || f.PackagePath != "example.com/logging" {
+				t.Errorf("Logger must be built by logging.NewLogger: %+v", f)
+			}
+		case "Metrics":
+			if f.FuncName != "OpenMetrics" || f.Set != "logging.ProviderSet" || f.Dependencies[0].Method != "Logger" {
+				t.Errorf("Metrics must be built by logging.OpenMetrics: %+v", f)
+			}
+		}
+	}
+
+	for _, c := range []struct {
+		set string
+		err string
+	}{
+		{"NotASet", "Container: include logging.NotASet: NotASet is not annotated with +DICON:set"},
+		{"PrivateSet", "Container: example.com/logging.newMetrics of logging.PrivateSet must be exported"},
+		{"NewLogger", "Container: include logging.NewLogger: NewLogger is not a variable annotated with +DICON:set"},
+	} {
+		pkg := parseTestPackage(t, "example.com/api", strings.Replace(TEST_SET_CONTAINER, "ProviderSet", c.set, 1), lib)
+		if _, err := NewPackageParser(pkg).FindDicons(); err == nil || err.Error() != c.err {
+			t.Errorf("%s: unexpected error: %v", c.set, err)
+		}
+	}
+}
+
+var TEST_DECORATE = `
+package di
+
+type UserService interface {
+	Exec() error
+}
+
+type Metrics struct{}
+
+// +DICON
+type Container interface {
+	Metrics() (*Metrics, error)
+	UserService() (UserService, error)
+}
+
+// +DICON:decorate order=3
+func DecorateUserService(s UserService, m *Metrics) (UserService, error) {
+	return s, nil
+}
+`
+
+func TestPackageParser_FindConstructorsDecorate(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_DECORATE)
+	p := NewPackageParser(pkg)
+	its, err := p.FindDicons()
+	if err != nil || len(its) != 1 {
+		t.Fatalf("+DICON not found: %v", err)
+	}
+	fs, err := p.FindConstructors(its[0].Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 1 || !fs[0].Decorator || fs[0].Name != "UserService" || fs[0].Order != 3 {
+		t.Errorf("DecorateUserService must decorate UserService: %+v", fs)
+	}
+
+	for _, c := range []struct {
+		annotation string
+		err        string
+	}{
+		{"+DICON:decorate order=first", "DecorateUserService: malformed decorate 'order=first': order must be an integer"},
+		{"+DICON:decorate Metrics", "DecorateUserService decorates 'Metrics', but takes UserService instead of *Metrics"},
+	} {
+		pkg := parseTestPackage(t, "di", strings.Replace(TEST_DECORATE, "+DICON:decorate order=3", c.annotation, 1))
+		p := NewPackageParser(pkg)
+		its, _ := p.FindDicons()
+		if _, err := p.FindConstructors(its[0].Funcs); err == nil || err.Error() != c.err {
+			t.Errorf("%s: unexpected error: %v", c.annotation, err)
+		}
+	}
+}
+
+var TEST_DECORATE_CONTAINERS = `
+package di
+
+type UserService interface {
+	Exec() error
+}
+
+type Metrics struct{}
+
+// +DICON
+type APIContainer interface {
+	UserService() (UserService, error)
+}
+
+// +DICON out=worker_gen
+type WorkerContainer interface {
+	Metrics() (*Metrics, error)
+}
+
+// +DICON:decorate UserService
+func DecorateUserService(s UserService) UserService {
+	return s
+}
+`
+
+func TestCheckDecorators(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_DECORATE_CONTAINERS)
+	p := NewPackageParser(pkg)
+	its, err := p.FindDicons()
+	if err != nil || len(its) != 2 {
+		t.Fatalf("+DICON not found: %v", err)
+	}
+	fs, err := p.FindConstructors(its[0].Funcs)
+	if err != nil || len(fs) != 1 || !fs[0].Decorator {
+		t.Errorf("DecorateUserService must decorate APIContainer: %+v, %v", fs, err)
+	}
+	fs, err = p.FindConstructors(its[1].Funcs)
+	if err != nil || len(fs) != 0 {
+		t.Errorf("DecorateUserService must be left out of WorkerContainer: %+v, %v", fs, err)
+	}
+	if err := CheckDecorators([]*packages.Package{pkg}, its); err != nil {
+		t.Error(err)
+	}
+
+	pkg = parseTestPackage(t, "di", strings.Replace(TEST_DECORATE_CONTAINERS, "+DICON:decorate UserService", "+DICON:decorate Users", 1))
+	its, _ = NewPackageParser(pkg).FindDicons()
+	if err := CheckDecorators([]*packages.Package{pkg}, its); err == nil ||
+		err.Error() != "DecorateUserService decorates unknown container method 'Users'" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+var TEST_MOCK_TARGETS = `
+package di
+
+import "context"
+
+type UserRepository interface {
+	Find() error
+}
+
+// +DICON
+type Container interface {
+	Users() (UserRepository, error)
+	Admins() (UserRepository, error)
+	Service() (*Service, error)
+	NewScope() Container
+	Close(ctx context.Context) error
+}
+
+type Service struct{}
+`
+
+func TestInterfaceType_ComponentTypeNames(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_MOCK_TARGETS)
+	its, err := NewPackageParser(pkg).FindDicons()
+	if err != nil || len(its) != 1 {
+		t.Fatalf("+DICON not found: %v", err)
+	}
+	if got := its[0].ComponentTypeNames(); !reflect.DeepEqual(got, []string{"UserRepository", "Service"}) {
+		t.Errorf("mock targets must be the returned types but %v", got)
+	}
+}
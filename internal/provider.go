@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"reflect"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// provides reports whether the function f is annotated with
+// "+DICON:provide <Method>..." for the container method name.
+func (f FuncType) provides(name string) bool {
+	for _, a := range f.Comments.annotations("provide") {
+		for _, m := range a.Args {
+			if m == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findProviders resolves the "+DICON:provider=<pkg>.<Func>" annotations of
+// the container methods declared in f. pkg is the name of a package imported
+// by f; the function is looked up in the container package when omitted.
+func findProviders(pkg *packages.Package, f *ast.File, it *InterfaceType) error {
+	for i := range it.Funcs {
+		m := &it.Funcs[i]
+		if len(m.ReturnTypes) == 0 {
+			continue
+		}
+		if a, ok := m.Comments.annotation("provider"); ok {
+			obj, err := lookupObject(pkg, f, a.Value)
+			if err != nil {
+				return fmt.Errorf("provider of '%s': %v", m.Name, err)
+			}
+			fn, ok := obj.(*types.Func)
+			if !ok {
+				return fmt.Errorf("provider of '%s': '%s' is not a function", m.Name, a.Value)
+			}
+			if !fn.Exported() && fn.Pkg() != pkg.Types {
+				return fmt.Errorf("provider of '%s': '%s' is not exported", m.Name, a.Value)
+			}
+			p, err := providerFunc(fn, *m)
+			if err != nil {
+				return err
+			}
+			m.Provider = &p
+		}
+		if a, ok := m.Comments.annotation("bind"); ok {
+			p, err := bindType(pkg, f, a.Value, *m)
+			if err != nil {
+				return err
+			}
+			m.Bind = &p
+		}
+		if a, ok := m.Comments.annotation("impl"); ok {
+			ref := a.Value
+			if ref == "" && len(a.Args) > 0 {
+				ref = a.Args[0]
+			}
+			obj, err := lookupObject(pkg, f, ref)
+			if err != nil {
+				return fmt.Errorf("impl of '%s': %v", m.Name, err)
+			}
+			tn, ok := obj.(*types.TypeName)
+			if !ok {
+				return fmt.Errorf("impl of '%s': '%s' is not a type", m.Name, ref)
+			}
+			if !tn.Exported() && tn.Pkg() != pkg.Types {
+				return fmt.Errorf("impl of '%s': '%s' is not exported", m.Name, ref)
+			}
+			p, err := implFunc(tn, pkg.PkgPath, *m)
+			if err != nil {
+				return err
+			}
+			m.Provider = &p
+		}
+	}
+	return nil
+}
+
+func lookupObject(pkg *packages.Package, f *ast.File, ref string) (types.Object, error) {
+	if pkg.Types == nil || pkg.TypesInfo == nil {
+		return nil, fmt.Errorf("package %s is not type checked", pkg.PkgPath)
+	}
+	scope := pkg.Types.Scope()
+	name := ref
+	if i := strings.LastIndex(ref, "."); i >= 0 {
+		qual := ref[:i]
+		name = ref[i+1:]
+		scope = nil
+		for _, spec := range f.Imports {
+			if pn := pkg.TypesInfo.PkgNameOf(spec); pn != nil && pn.Name() == qual {
+				scope = pn.Imported().Scope()
+				break
+			}
+		}
+		if scope == nil {
+			return nil, fmt.Errorf("package '%s' of '%s' is not imported", qual, ref)
+		}
+	}
+	obj := scope.Lookup(name)
+	if obj == nil {
+		return nil, fmt.Errorf("'%s' not found", ref)
+	}
+	return obj, nil
+}
+
+// providerFunc builds the constructor of the container method m from the
+// type checked function fn.
+func providerFunc(fn *types.Func, m FuncType) (FuncType, error) {
+	sig := fn.Type().(*types.Signature)
+	if sig.Recv() != nil {
+		return FuncType{}, fmt.Errorf("provider of '%s' must be a function, but %s is a method", m.Name, fn.FullName())
+	}
+	pkgName := fn.Pkg().Name()
+
+	var args []ParameterType
+	for i := 0; i < sig.Params().Len(); i++ {
+		v := sig.Params().At(i)
+		args = append(args, ParameterType{DeclaredPackageName: pkgName, Name: v.Name(), typ: v.Type()})
+	}
+	var returns []ParameterType
+	for i := 0; i < sig.Results().Len(); i++ {
+		returns = append(returns, ParameterType{DeclaredPackageName: pkgName, typ: sig.Results().At(i).Type()})
+	}
+	if len(returns) == 0 || len(returns) > 3 {
+		return FuncType{}, fmt.Errorf("provider %s of '%s' must return T, (T, error) or (T, func(), error)", fn.FullName(), m.Name)
+	}
+	if err := checkConstructorResults(pkgName, fn.Name(), returns); err != nil {
+		return FuncType{}, err
+	}
+	returns, err := provideAs(fn.Name(), returns, m)
+	if err != nil {
+		return FuncType{}, err
+	}
+
+	return FuncType{
+		ArgumentTypes: args,
+		ReturnTypes:   returns,
+		PackageName:   pkgName,
+		PackagePath:   fn.Pkg().Path(),
+		Name:          m.Name,
+		FuncName:      fn.Name(),
+		Variadic:      sig.Variadic(),
+	}, nil
+}
+
+// provideAs checks that a function annotated as the provider of the
+// container method m returns an instance assignable to the method result,
+// and returns its results typed as the method result.
+func provideAs(funcName string, returns []ParameterType, m FuncType) ([]ParameterType, error) {
+	ret, target := returns[0], m.ReturnTypes[0]
+	if !ret.Identical(target) {
+		t1, t2 := ret.Type(), target.Type()
+		if t1 == nil || t2 == nil || !types.AssignableTo(t1, t2) {
+			return nil, fmt.Errorf("%s provides '%s', but returns %s which is not assignable to %s",
+				funcName, m.Name, ret.ConvertName(ret.DeclaredPackageName), target.ConvertName(ret.DeclaredPackageName))
+		}
+	}
+	return append([]ParameterType{target}, returns[1:]...), nil
+}
+
+// bindType resolves "+DICON:bind=[*]<type>" of the container method m,
+// checking that the type is assignable to the method result.
+func bindType(pkg *packages.Package, f *ast.File, ref string, m FuncType) (ParameterType, error) {
+	obj, err := lookupObject(pkg, f, strings.TrimPrefix(ref, "*"))
+	if err != nil {
+		return ParameterType{}, fmt.Errorf("bind of '%s': %v", m.Name, err)
+	}
+	tn, ok := obj.(*types.TypeName)
+	if !ok {
+		return ParameterType{}, fmt.Errorf("bind of '%s': '%s' is not a type", m.Name, ref)
+	}
+	t := tn.Type()
+	if strings.HasPrefix(ref, "*") {
+		t = types.NewPointer(t)
+	}
+	target := m.ReturnTypes[0].Type()
+	if target == nil || !types.AssignableTo(t, target) {
+		return ParameterType{}, fmt.Errorf("bind of '%s': %s does not implement %s",
+			m.Name, ref, m.ReturnTypes[0].ConvertName(pkg.Name))
+	}
+	return ParameterType{DeclaredPackageName: pkg.Name, typ: t}, nil
+}
+
+const injectTag = "inject"
+
+// implFunc builds the container method m from a literal of the struct tn.
+// The fields tagged with `dicon:"inject"` are injected, or every exported
+// field when no field is tagged.
+func implFunc(tn *types.TypeName, pkgPath string, m FuncType) (FuncType, error) {
+	st, ok := tn.Type().Underlying().(*types.Struct)
+	if !ok {
+		return FuncType{}, fmt.Errorf("impl %s of '%s' must be a struct", tn.Name(), m.Name)
+	}
+	impl := ParameterType{DeclaredPackageName: tn.Pkg().Name(), typ: types.NewPointer(tn.Type())}
+	if t := m.ReturnTypes[0].Type(); t == nil || !types.AssignableTo(impl.typ, t) {
+		impl.typ = tn.Type()
+		if t == nil || !types.AssignableTo(impl.typ, t) {
+			return FuncType{}, fmt.Errorf("impl %s of '%s' is not assignable to %s",
+				tn.Name(), m.Name, m.ReturnTypes[0].ConvertName(m.ReturnTypes[0].DeclaredPackageName))
+		}
+	}
+
+	var tagged, exported []ParameterType
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		p := ParameterType{DeclaredPackageName: tn.Pkg().Name(), Name: field.Name(), typ: field.Type()}
+		switch reflect.StructTag(st.Tag(i)).Get(configTag) {
+		case injectTag:
+			if !field.Exported() && tn.Pkg().Path() != pkgPath {
+				return FuncType{}, fmt.Errorf("impl %s of '%s': field %s must be exported to be injected", tn.Name(), m.Name, field.Name())
+			}
+			tagged = append(tagged, p)
+		case "-":
+		default:
+			if field.Exported() {
+				exported = append(exported, p)
+			}
+		}
+	}
+	fields := tagged
+	if len(fields) == 0 {
+		fields = exported
+	}
+
+	return FuncType{
+		ArgumentTypes: fields,
+		ReturnTypes:   m.ReturnTypes[:1],
+		PackageName:   tn.Pkg().Name(),
+		PackagePath:   tn.Pkg().Path(),
+		Comments:      m.Comments,
+		Name:          m.Name,
+		Impl:          &impl,
+	}, nil
+}
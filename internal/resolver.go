@@ -0,0 +1,410 @@
+package internal
+
+import (
+	"fmt"
+	"go/types"
+	"strings"
+)
+
+// Dependency is the container method providing an argument of a
+// constructor, or the Field of the config it returns. Context is set for a
+// context.Context argument, which is passed through from the caller instead.
+type Dependency struct {
+	Method  string
+	Field   string
+	Context bool
+	// Group is the value group passed as a slice, or as a map keyed by
+	// method name when Keyed, built from the Members methods.
+	Group   string
+	Members []string
+	Keyed   bool
+	// Lazy is set when the argument is a thunk calling Method.
+	Lazy bool
+	// Optional is set when no container method provides the optional
+	// argument, which is passed its zero value.
+	Optional bool
+}
+
+// methods returns the container methods called to resolve d.
+func (d Dependency) methods() []string {
+	switch {
+	case d.Context, d.Optional:
+		return nil
+	case d.Group != "":
+		return d.Members
+	}
+	return []string{d.Method}
+}
+
+type UnresolvedDependencyError struct {
+	Component  string
+	Argument   string
+	Candidates []string
+}
+
+func (e *UnresolvedDependencyError) Error() string {
+	if len(e.Candidates) == 0 {
+		return fmt.Sprintf("resolve %s for '%s' failed: no container method provides it", e.Argument, e.Component)
+	}
+	return fmt.Sprintf("resolve %s for '%s' failed: multiple container methods provide it ('%s'); "+
+		"name the argument after one of them or annotate the constructor with +DICON:inject",
+		e.Argument, e.Component, strings.Join(e.Candidates, "', '"))
+}
+
+type providerIndex struct {
+	methods []FuncType
+	configs []FuncType
+	structs map[string]*types.Struct
+
+	groups     map[string][]string
+	groupNames []string
+}
+
+func newProviderIndex(it *InterfaceType) (*providerIndex, error) {
+	idx := &providerIndex{
+		methods: it.components(),
+		structs: map[string]*types.Struct{},
+	}
+	for _, m := range idx.methods {
+		if !m.isConfig() {
+			continue
+		}
+		st, err := configStruct(m)
+		if err != nil {
+			return nil, err
+		}
+		idx.configs = append(idx.configs, m)
+		idx.structs[m.Name] = st
+	}
+	return idx, nil
+}
+
+func (idx *providerIndex) method(name string) (FuncType, bool) {
+	if i := indexOfFunc(name, idx.methods); i >= 0 {
+		return idx.methods[i], true
+	}
+	return FuncType{}, false
+}
+
+// lookup returns the names of the container methods whose result can be
+// passed as p. A method returning exactly the type of p wins over the ones
+// returning merely assignable types.
+func (idx *providerIndex) lookup(p ParameterType) []string {
+	var identical, assignable []string
+	for _, m := range idx.methods {
+		ret := m.ReturnTypes[0]
+		if ret.Identical(p) {
+			identical = append(identical, m.Name)
+			continue
+		}
+		if t1, t2 := ret.Type(), p.Type(); t1 != nil && t2 != nil && types.AssignableTo(t1, t2) {
+			assignable = append(assignable, m.Name)
+		}
+	}
+	if len(identical) > 0 {
+		return identical
+	}
+	return assignable
+}
+
+// lookupConfig returns the config fields which can be passed as p.
+func (idx *providerIndex) lookupConfig(p ParameterType, pkgPath string) []Dependency {
+	var res []Dependency
+	for _, m := range idx.configs {
+		if field, ok := configField(idx.structs[m.Name], pkgPath, p); ok {
+			res = append(res, Dependency{Method: m.Name, Field: field})
+		}
+	}
+	return res
+}
+
+// injections returns the container methods bound to the arguments of the
+// constructor f by "+DICON:inject <argument>=<Method>" annotations.
+func (idx *providerIndex) injections(f FuncType) (map[string]string, error) {
+	res := map[string]string{}
+	for _, a := range f.Comments.annotations("inject") {
+		for _, arg := range a.Args {
+			i := strings.Index(arg, "=")
+			if i <= 0 || i == len(arg)-1 {
+				return nil, fmt.Errorf("%s: malformed inject '%s': must be <argument>=<Method>", f.constructorName(), arg)
+			}
+			name, method := arg[:i], arg[i+1:]
+			p, ok := argumentByName(f, name)
+			if !ok {
+				return nil, fmt.Errorf("%s: inject '%s' refers to unknown argument '%s'", f.constructorName(), arg, name)
+			}
+			m, ok := idx.method(method)
+			if !ok {
+				return nil, fmt.Errorf("%s: inject '%s' refers to unknown container method '%s'", f.constructorName(), arg, method)
+			}
+			if t1, t2 := m.ReturnTypes[0].Type(), p.Type(); t1 != nil && t2 != nil && !types.AssignableTo(t1, t2) {
+				return nil, fmt.Errorf("%s: inject '%s': %s returns %s, which is not assignable to %s",
+					f.constructorName(), arg, method, t1, t2)
+			}
+			res[name] = method
+		}
+	}
+	return res, nil
+}
+
+// optionals returns the arguments of the constructor f annotated with
+// "+DICON:optional <argument>...".
+func (idx *providerIndex) optionals(f FuncType) (map[string]bool, error) {
+	res := map[string]bool{}
+	for _, a := range f.Comments.annotations("optional") {
+		for _, name := range a.Args {
+			if _, ok := argumentByName(f, name); !ok {
+				return nil, fmt.Errorf("%s: optional refers to unknown argument '%s'", f.constructorName(), name)
+			}
+			res[name] = true
+		}
+	}
+	return res, nil
+}
+
+func argumentByName(f FuncType, name string) (ParameterType, bool) {
+	for _, a := range f.ArgumentTypes {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return ParameterType{}, false
+}
+
+// qualifyByName narrows down the candidates providing an argument to the
+// method named like the argument, e.g. ReplicaDB for `replicaDB *sql.DB`.
+func qualifyByName(candidates []string, name string) []string {
+	for _, c := range candidates {
+		if name != "" && strings.EqualFold(c, name) {
+			return []string{c}
+		}
+	}
+	return candidates
+}
+
+// resolveArguments wires the arguments args of the constructor f to the
+// container methods providing them.
+func (idx *providerIndex) resolveArguments(it *InterfaceType, f FuncType, args []ParameterType) ([]Dependency, error) {
+	injects, err := idx.injections(f)
+	if err != nil {
+		return nil, err
+	}
+	optionals, err := idx.optionals(f)
+	if err != nil {
+		return nil, err
+	}
+	deps := make([]Dependency, 0, len(args))
+	for _, a := range args {
+		if isContextType(a) {
+			deps = append(deps, Dependency{Context: true})
+			continue
+		}
+		if m, ok := injects[a.Name]; ok {
+			deps = append(deps, Dependency{Method: m})
+			continue
+		}
+		candidates := idx.lookup(a)
+		if len(candidates) > 1 {
+			candidates = qualifyByName(candidates, a.Name)
+		}
+		if t, ok := lazyType(a); ok && len(candidates) == 0 {
+			candidates = qualifyByName(idx.lookup(t), a.Name)
+			if len(candidates) == 1 {
+				deps = append(deps, Dependency{Method: candidates[0], Lazy: true})
+				continue
+			}
+		}
+		if len(candidates) == 0 {
+			d, groups, ok := idx.lookupGroup(a)
+			if ok {
+				deps = append(deps, d)
+				continue
+			}
+			candidates = groupCandidates(groups)
+		}
+		if len(candidates) == 0 {
+			fields := idx.lookupConfig(a, it.PackagePath)
+			if len(fields) == 1 {
+				deps = append(deps, fields[0])
+				continue
+			}
+			for _, d := range fields {
+				candidates = append(candidates, d.Method+"."+d.Field)
+			}
+		}
+		if len(candidates) == 0 && optionals[a.Name] {
+			deps = append(deps, Dependency{Optional: true})
+			continue
+		}
+		if len(candidates) != 1 {
+			return nil, &UnresolvedDependencyError{
+				Component:  f.Name,
+				Argument:   a.ConvertName(it.PackageName),
+				Candidates: candidates,
+			}
+		}
+		deps = append(deps, Dependency{Method: candidates[0]})
+	}
+	return deps, nil
+}
+
+// ResolveDependencies selects one constructor for every container method and
+// wires each of its arguments to the container method providing it.
+func ResolveDependencies(it *InterfaceType, funcs []FuncType) ([]FuncType, error) {
+	fields := map[string]string{}
+	for _, f := range it.Funcs {
+		if contains(f.Name, containerFields) {
+			return nil, fmt.Errorf("container method '%s' collides with a field or method of the generated container: rename it", f.Name)
+		}
+		field := fieldName(f.Name)
+		if other, ok := fields[field]; ok {
+			return nil, fmt.Errorf("container methods '%s' and '%s' would both be held by the field %s of the generated container: rename one of them",
+				other, f.Name, field)
+		}
+		fields[field] = f.Name
+	}
+	idx, err := newProviderIndex(it)
+	if err != nil {
+		return nil, err
+	}
+	funcs, decorators := splitDecorators(funcs)
+	funcs = append(funcs, it.SetProviders...)
+	// the methods bound to a provider or to the config are not built by
+	// the constructors found by name.
+	for _, m := range idx.methods {
+		if m.Provider != nil || m.isConfig() {
+			funcs = removeFunc(m.Name, funcs)
+		}
+	}
+	funcs, err = selectConstructors(funcs)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range idx.methods {
+		if m.Provider != nil {
+			funcs = append(funcs, *m.Provider)
+		}
+	}
+	for _, m := range idx.configs {
+		funcs = append(funcs, FuncType{
+			Name:        m.Name,
+			ReturnTypes: m.ReturnTypes[:1],
+			PackageName: it.PackageName,
+			PackagePath: it.PackagePath,
+			Comments:    m.Comments,
+			Config:      true,
+		})
+	}
+	idx.addGroups(funcs)
+
+	for i := range funcs {
+		f := &funcs[i]
+		if m, ok := idx.method(f.Name); ok {
+			scope, err := normalizeScope(m)
+			if err != nil {
+				return nil, err
+			}
+			f.Scope = scope
+			if scope == requestScope && !it.hasScopeMethod() {
+				return nil, fmt.Errorf("request scoped '%s' requires the container to declare NewScope() %s", m.Name, it.Name)
+			}
+			if scope == transientScope && f.hasCleanup() {
+				return nil, fmt.Errorf("transient '%s' must not return a cleanup function, which would be registered on every build: "+
+					"make it singleton or request scoped", m.Name)
+			}
+			if len(m.ArgumentTypes) > 0 && !m.takesContext() {
+				return nil, fmt.Errorf("container method '%s' must take no argument or a context.Context", m.Name)
+			}
+			if len(m.ReturnTypes) != 2 || !isErrorType(m.ReturnTypes[1]) {
+				return nil, fmt.Errorf("container method '%s' must return (%s, error)", m.Name, m.ReturnTypes[0].ConvertName(it.PackageName))
+			}
+			f.TakesContext = m.takesContext()
+		}
+		deps, err := idx.resolveArguments(it, *f, f.ArgumentTypes)
+		if err != nil {
+			return nil, err
+		}
+		f.Dependencies = deps
+	}
+	if err := idx.decorate(it, funcs, decorators); err != nil {
+		return nil, err
+	}
+
+	for _, m := range idx.methods {
+		if indexOfFunc(m.Name, funcs) >= 0 {
+			continue
+		}
+		t := m.constructedType()
+		names := "New" + m.Name
+		if n := t.SimpleName(); n != m.Name {
+			names += " or New" + n
+		}
+		return nil, fmt.Errorf("constructor for '%s' not found: declare %s returning %s",
+			m.Name, names, t.ConvertName(it.PackageName))
+	}
+
+	if err := checkScopes(funcs); err != nil {
+		return nil, err
+	}
+
+	return funcs, nil
+}
+
+// selectConstructors keeps a single constructor per container method,
+// preferring the functions annotated with +DICON:provide or included from
+// a provider set, then New<Method> over New<ReturnType>. Two different
+// functions of the same rank are ambiguous.
+func selectConstructors(funcs []FuncType) ([]FuncType, error) {
+	res := make([]FuncType, 0, len(funcs))
+	pos := make(map[string]int, len(funcs))
+	for _, f := range funcs {
+		i, ok := pos[f.Name]
+		if !ok {
+			pos[f.Name] = len(res)
+			res = append(res, f)
+			continue
+		}
+		switch r1, r2 := constructorRank(f), constructorRank(res[i]); {
+		case r1 > r2:
+			res[i] = f
+		case r1 == r2 && qualifiedFuncName(f) != qualifiedFuncName(res[i]):
+			return nil, fmt.Errorf("multiple constructors build '%s' (%s and %s): annotate one of them with +DICON:provide %s",
+				f.Name, qualifiedFuncName(res[i]), qualifiedFuncName(f), f.Name)
+		}
+	}
+	return res, nil
+}
+
+func qualifiedFuncName(f FuncType) string {
+	return f.PackagePath + "." + f.constructorName()
+}
+
+func constructorRank(f FuncType) int {
+	switch {
+	case f.provides(f.Name), f.Set != "":
+		return 2
+	case f.constructorName() == "New"+f.Name:
+		return 1
+	}
+	return 0
+}
+
+func removeFunc(name string, funcs []FuncType) []FuncType {
+	res := funcs[:0]
+	for _, f := range funcs {
+		if f.Name != name {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// indexOfFunc returns the index of the function named name in funcs, or -1.
+func indexOfFunc(name string, funcs []FuncType) int {
+	for i, f := range funcs {
+		if f.Name == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,686 @@
+package internal
+
+import (
+	"go/ast"
+	"go/types"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var TEST_RESOLVE = `
+package di
+
+type UserRepository interface {
+	Find() error
+}
+
+type UserService interface {
+	Exec() error
+}
+
+// +DICON
+type Container interface {
+	Users() (UserRepository, error)
+	UserService() (UserService, error)
+}
+
+func NewUserRepository() (UserRepository, error) {
+	return nil, nil
+}
+
+func NewUserService(repo UserRepository) (UserService, error) {
+	return nil, nil
+}
+`
+
+func TestResolveDependencies(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_RESOLVE)
+	it := findDicon(pkg, pkg.Syntax[0], "+DICON")[0]
+	fs, err := findConstructors(pkg, pkg.Syntax[0], it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = ResolveDependencies(&it, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 2 {
+		t.Fatalf("must be 2 constructors but %d", len(fs))
+	}
+	for _, f := range fs {
+		switch f.Name {
+		case "Users":
+			if f.FuncName != "NewUserRepository" {
+				t.Errorf("Users must be built by NewUserRepository but %s", f.FuncName)
+			}
+		case "UserService":
+			if len(f.Dependencies) != 1 || f.Dependencies[0].Method != "Users" {
+				t.Errorf("UserRepository must be resolved by Users but %v", f.Dependencies)
+			}
+		default:
+			t.Errorf("unexpected constructor %s", f.Name)
+		}
+	}
+}
+
+var TEST_RESOLVE_ASSIGNABLE = `
+package di
+
+type UserRepository interface {
+	Find() error
+}
+
+type PostgresRepository struct{}
+
+func (*PostgresRepository) Find() error { return nil }
+
+type UserService interface {
+	Exec() error
+}
+
+// +DICON
+type Container interface {
+	Postgres() (*PostgresRepository, error)
+	UserService() (UserService, error)
+}
+
+func NewPostgres() (*PostgresRepository, error) {
+	return nil, nil
+}
+
+func NewUserService(repo UserRepository) (UserService, error) {
+	return nil, nil
+}
+`
+
+func TestResolveDependenciesAssignable(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_RESOLVE_ASSIGNABLE)
+	it := findDicon(pkg, pkg.Syntax[0], "+DICON")[0]
+	fs, err := findConstructors(pkg, pkg.Syntax[0], it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err = ResolveDependencies(&it, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fs {
+		if f.Name == "UserService" && f.Dependencies[0].Method != "Postgres" {
+			t.Errorf("UserRepository must be resolved by Postgres but %s", f.Dependencies[0].Method)
+		}
+	}
+}
+
+var TEST_RESOLVE_AMBIGUOUS = `
+package di
+
+type DB interface {
+	Query() error
+}
+
+type UserService interface {
+	Exec() error
+}
+
+// +DICON
+type Container interface {
+	PrimaryDB() (DB, error)
+	ReplicaDB() (DB, error)
+	UserService() (UserService, error)
+}
+
+func NewPrimaryDB() (DB, error) {
+	return nil, nil
+}
+
+func NewReplicaDB() (DB, error) {
+	return nil, nil
+}
+
+func NewUserService(db DB, s string) (UserService, error) {
+	return nil, nil
+}
+`
+
+func TestResolveDependenciesAmbiguous(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_RESOLVE_AMBIGUOUS)
+	it := findDicon(pkg, pkg.Syntax[0], "+DICON")[0]
+	fs, err := findConstructors(pkg, pkg.Syntax[0], it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ResolveDependencies(&it, fs)
+	e, ok := err.(*UnresolvedDependencyError)
+	if !ok {
+		t.Fatalf("must be UnresolvedDependencyError but %v", err)
+	}
+	if e.Component != "UserService" || len(e.Candidates) != 2 || e.Candidates[0] != "PrimaryDB" || e.Candidates[1] != "ReplicaDB" {
+		t.Errorf("unexpected error: %v", e)
+	}
+
+	it.Funcs = it.Funcs[:1]
+	_, err = ResolveDependencies(&it, []FuncType{fs[0], fs[2]})
+	e, ok = err.(*UnresolvedDependencyError)
+	if !ok {
+		t.Fatalf("must be UnresolvedDependencyError but %v", err)
+	}
+	if e.Argument != "string" || len(e.Candidates) != 0 {
+		t.Errorf("unexpected error: %v", e)
+	}
+}
+
+var TEST_RESOLVE_SCOPES = `
+package di
+
+type Session interface{}
+
+type Handler interface{}
+
+type Logger interface{}
+
+// +DICON
+type Container interface {
+	// +DICON:scope=request
+	Session() (Session, error)
+	// +DICON:scope=transient
+	Handler() (Handler, error)
+	Logger() (Logger, error)
+	NewScope() Container
+}
+
+func NewSession() (Session, error) {
+	return nil, nil
+}
+
+func NewHandler(s Session) (Handler, error) {
+	return nil, nil
+}
+
+func NewLogger(h Handler) (Logger, error) {
+	return nil, nil
+}
+`
+
+func TestResolveDependenciesScopes(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_RESOLVE_SCOPES)
+	it := findDicon(pkg, pkg.Syntax[0], "+DICON")[0]
+	if !it.hasScopeMethod() || len(it.components()) != 3 {
+		t.Fatalf("NewScope must not be a component: %v", it.components())
+	}
+	fs, err := findConstructors(pkg, pkg.Syntax[0], it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ResolveDependencies(&it, fs)
+	if err == nil || err.Error() != "singleton 'Logger' must not depend on request scoped 'Session'" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	it.Funcs[2].Scope = "request"
+	fs, err = ResolveDependencies(&it, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fs {
+		if f.Name == "Handler" && f.Scope != transientScope {
+			t.Errorf("Handler must be transient but %s", f.Scope)
+		}
+	}
+
+	it.Funcs[2].Scope = "session"
+	if _, err := ResolveDependencies(&it, fs); err == nil {
+		t.Errorf("unknown scope must be an error")
+	}
+
+	it.Funcs[2].Scope = "request"
+	it.Funcs = it.Funcs[:3]
+	if _, err := ResolveDependencies(&it, fs); err == nil ||
+		err.Error() != "request scoped 'Session' requires the container to declare NewScope() Container" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+var TEST_RESOLVE_TRANSIENT_CLEANUP = `
+package di
+
+type Cache interface{}
+
+// +DICON
+type Container interface {
+	// +DICON:scope=transient
+	Cache() (Cache, error)
+}
+
+func NewCache() (Cache, func(), error) {
+	return nil, func() {}, nil
+}
+`
+
+func TestResolveDependenciesTransientCleanup(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_RESOLVE_TRANSIENT_CLEANUP)
+	it := findDicon(pkg, pkg.Syntax[0], "+DICON")[0]
+	fs, err := findConstructors(pkg, pkg.Syntax[0], it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ResolveDependencies(&it, fs)
+	if err == nil || !strings.HasPrefix(err.Error(), "transient 'Cache' must not return a cleanup function") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	it.Funcs[0].Scope = ""
+	if _, err := ResolveDependencies(&it, fs); err != nil {
+		t.Errorf("a singleton may return a cleanup function: %v", err)
+	}
+}
+
+func TestResolveDependenciesContainerFields(t *testing.T) {
+	it := &InterfaceType{
+		Name:  "Container",
+		Funcs: []FuncType{{Name: "opts", ReturnTypes: []ParameterType{{src: ast.NewIdent("Options")}}}},
+	}
+	_, err := ResolveDependencies(it, nil)
+	if err == nil || err.Error() != "container method 'opts' collides with a field or method of the generated container: rename it" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	it.Funcs[0].Name = "onClose"
+	_, err = ResolveDependencies(it, nil)
+	if err == nil || err.Error() != "container method 'onClose' collides with a field or method of the generated container: rename it" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestResolveDependenciesFieldCollision(t *testing.T) {
+	it := &InterfaceType{
+		Name: "Container",
+		Funcs: []FuncType{
+			{Name: "DB", ReturnTypes: []ParameterType{{src: ast.NewIdent("DB")}}},
+			{Name: "dB", ReturnTypes: []ParameterType{{src: ast.NewIdent("DB")}}},
+		},
+	}
+	_, err := ResolveDependencies(it, nil)
+	if err == nil || err.Error() != "container methods 'DB' and 'dB' would both be held by the field dB of the generated container: rename one of them" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+var TEST_RESOLVE_CONTEXT = `
+package di
+
+import "context"
+
+type DB interface{}
+
+type UserService interface{}
+
+// +DICON
+type Container interface {
+	DB(ctx context.Context) (DB, error)
+	UserService(name string) (UserService, error)
+}
+
+func NewDB(ctx context.Context) (DB, error) {
+	return nil, nil
+}
+
+func NewUserService(ctx context.Context, db DB) (UserService, error) {
+	return nil, nil
+}
+`
+
+func TestResolveDependenciesContext(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_RESOLVE_CONTEXT)
+	it := findDicon(pkg, pkg.Syntax[0], "+DICON")[0]
+	fs, err := findConstructors(pkg, pkg.Syntax[0], it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ResolveDependencies(&it, fs)
+	if err == nil || err.Error() != "container method 'UserService' must take no argument or a context.Context" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	it.Funcs[1].ArgumentTypes = nil
+	fs, err = ResolveDependencies(&it, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fs {
+		switch f.Name {
+		case "DB":
+			if !f.TakesContext || len(f.Dependencies) != 1 || !f.Dependencies[0].Context {
+				t.Errorf("DB must take the context: %+v", f)
+			}
+		case "UserService":
+			if f.TakesContext || len(f.Dependencies) != 2 || !f.Dependencies[0].Context || f.Dependencies[1].Method != "DB" {
+				t.Errorf("unexpected dependencies of UserService: %+v", f.Dependencies)
+			}
+		}
+	}
+}
+
+var TEST_RESOLVE_RESULTS = `
+package di
+
+type Foo struct{}
+
+// +DICON
+type Container interface {
+	Foo() *Foo
+	Bar() (*Foo, string)
+}
+
+func NewFoo() *Foo {
+	return &Foo{}
+}
+
+func NewBar() *Foo {
+	return &Foo{}
+}
+`
+
+func TestResolveDependenciesResults(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_RESOLVE_RESULTS)
+	it := findDicon(pkg, pkg.Syntax[0], "+DICON")[0]
+	fs, err := findConstructors(pkg, pkg.Syntax[0], it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ResolveDependencies(&it, fs)
+	if err == nil || err.Error() != "container method 'Foo' must return (*Foo, error)" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	it.Funcs = it.Funcs[1:]
+	_, err = ResolveDependencies(&it, fs)
+	if err == nil || err.Error() != "container method 'Bar' must return (*Foo, error)" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+var TEST_RESOLVE_CONFIG = `
+package di
+
+import "time"
+
+type Config struct {
+	Timeout time.Duration
+	Retry   time.Duration ` + "`dicon:\"interval\"`" + `
+	Name    string        ` + "`dicon:\"-\"`" + `
+}
+
+type Client interface{}
+
+// +DICON
+type Container interface {
+	// +DICON:config
+	Config() (*Config, error)
+	Client() (Client, error)
+}
+
+func NewClient(timeout, interval time.Duration, name string) (Client, error) {
+	return nil, nil
+}
+`
+
+func TestResolveDependenciesConfig(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_RESOLVE_CONFIG)
+	it := findDicon(pkg, pkg.Syntax[0], "+DICON")[0]
+	fs, err := findConstructors(pkg, pkg.Syntax[0], it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ResolveDependencies(&it, fs)
+	e, ok := err.(*UnresolvedDependencyError)
+	if !ok || e.Argument != "string" {
+		t.Fatalf("name must not be injected from the ignored field: %v", err)
+	}
+
+	fs[0].ArgumentTypes = fs[0].ArgumentTypes[:2]
+	res, err := ResolveDependencies(&it, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range res {
+		switch f.Name {
+		case "Config":
+			if !f.Config {
+				t.Errorf("Config must be provided by NewDIContainer")
+			}
+		case "Client":
+			ex := []Dependency{{Method: "Config", Field: "Timeout"}, {Method: "Config", Field: "Retry"}}
+			if !reflect.DeepEqual(f.Dependencies, ex) {
+				t.Errorf("expected %v, but got %v", ex, f.Dependencies)
+			}
+		}
+	}
+
+	it.Funcs[0].ReturnTypes[0] = ParameterType{src: ast.NewIdent("int"), typ: types.Typ[types.Int]}
+	if _, err := ResolveDependencies(&it, fs); err == nil {
+		t.Errorf("config must be a struct")
+	}
+}
+
+var TEST_RESOLVE_QUALIFIED = `
+package di
+
+type DB interface {
+	Query() error
+}
+
+type UserService interface{}
+
+type ReportService interface{}
+
+// +DICON
+type Container interface {
+	PrimaryDB() (DB, error)
+	ReplicaDB() (DB, error)
+	UserService() (UserService, error)
+	ReportService() (ReportService, error)
+}
+
+func NewPrimaryDB() (DB, error) {
+	return nil, nil
+}
+
+func NewReplicaDB() (DB, error) {
+	return nil, nil
+}
+
+func NewUserService(primaryDB DB) (UserService, error) {
+	return nil, nil
+}
+
+// NewReportService reads from the replica.
+// +DICON:inject db=ReplicaDB
+func NewReportService(db DB) (ReportService, error) {
+	return nil, nil
+}
+`
+
+func TestResolveDependenciesQualified(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_RESOLVE_QUALIFIED)
+	it := findDicon(pkg, pkg.Syntax[0], "+DICON")[0]
+	fs, err := findConstructors(pkg, pkg.Syntax[0], it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ResolveDependencies(&it, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range res {
+		switch f.Name {
+		case "UserService":
+			if f.Dependencies[0].Method != "PrimaryDB" {
+				t.Errorf("primaryDB must be resolved by PrimaryDB but %s", f.Dependencies[0].Method)
+			}
+		case "ReportService":
+			if f.Dependencies[0].Method != "ReplicaDB" {
+				t.Errorf("db must be injected by ReplicaDB but %s", f.Dependencies[0].Method)
+			}
+		}
+	}
+
+	for _, c := range []struct {
+		inject string
+		err    string
+	}{
+		{"database=ReplicaDB", "NewReportService: inject 'database=ReplicaDB' refers to unknown argument 'database'"},
+		{"db=SecondaryDB", "NewReportService: inject 'db=SecondaryDB' refers to unknown container method 'SecondaryDB'"},
+		{"db=UserService", "NewReportService: inject 'db=UserService': UserService returns di.UserService, which is not assignable to di.DB"},
+		{"db", "NewReportService: malformed inject 'db': must be <argument>=<Method>"},
+	} {
+		fs[3].Comments = comments{comment("+DICON:inject " + c.inject)}
+		if _, err := ResolveDependencies(&it, fs); err == nil || err.Error() != c.err {
+			t.Errorf("%s: unexpected error: %v", c.inject, err)
+		}
+	}
+}
+
+var TEST_RESOLVE_GROUP = `
+package di
+
+type Handler interface {
+	Serve()
+}
+
+type Subscriber interface {
+	Serve()
+	Topic() string
+}
+
+type Router struct{}
+
+// +DICON
+type Container interface {
+	// +DICON:group=handlers
+	Users() (Handler, error)
+	// +DICON:group=handlers
+	Orders() (Handler, error)
+	// +DICON:group=subscribers
+	Events() (Subscriber, error)
+	Router() (*Router, error)
+}
+
+func NewUsers() Handler { return nil }
+
+func NewOrders() Handler { return nil }
+
+func NewEvents() Subscriber { return nil }
+
+func NewRouter(handlers []Handler) *Router { return nil }
+`
+
+func TestResolveDependenciesGroup(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_RESOLVE_GROUP)
+	it := findDicon(pkg, pkg.Syntax[0], "+DICON")[0]
+	fs, err := findConstructors(pkg, pkg.Syntax[0], it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ResolveDependencies(&it, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range res {
+		if f.Name != "Router" {
+			continue
+		}
+		d := f.Dependencies[0]
+		if d.Group != "handlers" || d.Keyed || !reflect.DeepEqual(d.Members, []string{"Users", "Orders"}) {
+			t.Errorf("handlers must be resolved by the handlers group but %+v", d)
+		}
+	}
+
+	// both groups provide Handler values, so the argument must be named after one of them.
+	fs[3].ArgumentTypes[0].Name = "all"
+	_, err = ResolveDependencies(&it, fs)
+	if _, ok := err.(*UnresolvedDependencyError); !ok {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+var TEST_RESOLVE_OPTIONAL = `
+package di
+
+type Logger interface {
+	Log(string)
+}
+
+type UserService interface {
+	Exec() error
+}
+
+// +DICON
+type Container interface {
+	UserService() (UserService, error)
+}
+
+// +DICON:optional logger
+func NewUserService(logger Logger) (UserService, error) {
+	return nil, nil
+}
+`
+
+func TestResolveDependenciesOptional(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_RESOLVE_OPTIONAL)
+	it := findDicon(pkg, pkg.Syntax[0], "+DICON")[0]
+	fs, err := findConstructors(pkg, pkg.Syntax[0], it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := ResolveDependencies(&it, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := res[0].Dependencies[0]; !d.Optional || d.Method != "" {
+		t.Errorf("logger must be optional but %+v", d)
+	}
+
+	fs[0].Comments = comments{comment("+DICON:optional log")}
+	if _, err := ResolveDependencies(&it, fs); err == nil || err.Error() != "NewUserService: optional refers to unknown argument 'log'" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	fs[0].Comments = nil
+	if _, err := ResolveDependencies(&it, fs); err == nil {
+		t.Error("logger must be required without +DICON:optional")
+	}
+}
+
+func TestResolveDependenciesAmbiguousConstructors(t *testing.T) {
+	pkg := parseTestPackage(t, "di", TEST_RESOLVE)
+	it := findDicon(pkg, pkg.Syntax[0], "+DICON")[0]
+	fs, err := findConstructors(pkg, pkg.Syntax[0], it.Funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NewUserService found in two scanned packages
+	other := fs[1]
+	other.PackageName, other.PackagePath = "other", "example.com/other"
+	_, err = ResolveDependencies(&it, append(fs, other))
+	if err == nil || err.Error() != "multiple constructors build 'UserService' (di.NewUserService and example.com/other.NewUserService): "+
+		"annotate one of them with +DICON:provide UserService" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the same function found twice is not ambiguous
+	if _, err := ResolveDependencies(&it, append(fs, fs[1])); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
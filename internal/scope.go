@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"fmt"
+)
+
+const (
+	singletonScope = "singleton"
+	transientScope = "transient"
+	requestScope   = "request"
+)
+
+// normalizeScope validates the scope annotated on a container method.
+// Components are singletons unless annotated otherwise.
+func normalizeScope(f FuncType) (string, error) {
+	switch f.Scope {
+	case "", singletonScope:
+		return singletonScope, nil
+	case transientScope, requestScope:
+		return f.Scope, nil
+	}
+	return "", fmt.Errorf("unknown scope '%s' of '%s': must be one of %s, %s or %s",
+		f.Scope, f.Name, singletonScope, transientScope, requestScope)
+}
+
+// checkScopes rejects singletons depending on request scoped components,
+// directly or through transient ones, since the singleton would capture the
+// instance of the first scope resolving it.
+func checkScopes(funcs []FuncType) error {
+	byName := make(map[string]FuncType, len(funcs))
+	for _, f := range funcs {
+		byName[f.Name] = f
+	}
+	var find func(f FuncType, visited map[string]struct{}) string
+	find = func(f FuncType, visited map[string]struct{}) string {
+		for _, d := range f.allDependencies() {
+			for _, method := range d.methods() {
+				if _, ok := visited[method]; ok {
+					continue
+				}
+				visited[method] = struct{}{}
+				dep := byName[method]
+				switch dep.Scope {
+				case requestScope:
+					return dep.Name
+				case transientScope:
+					if name := find(dep, visited); name != "" {
+						return name
+					}
+				}
+			}
+		}
+		return ""
+	}
+	for _, f := range funcs {
+		if f.Scope != singletonScope {
+			continue
+		}
+		if name := find(f, map[string]struct{}{}); name != "" {
+			return fmt.Errorf("singleton '%s' must not depend on request scoped '%s'", f.Name, name)
+		}
+	}
+	return nil
+}
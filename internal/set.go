@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const setAnnotation = "+DICON:set"
+
+// findSetProviders resolves the "+DICON:include <pkg>.<Set>..." annotations
+// of the container it. A set is a variable annotated with +DICON:set, listing
+// provider functions and other sets:
+//
+//	// +DICON:set
+//	var ProviderSet = []interface{}{NewLogger, metrics.ProviderSet}
+//
+// Every function of the included sets builds the container methods whose
+// result it returns.
+func findSetProviders(pkg *packages.Package, f *ast.File, it *InterfaceType) error {
+	all := map[string]*packages.Package{}
+	packages.Visit([]*packages.Package{pkg}, func(p *packages.Package) bool {
+		all[p.PkgPath] = p
+		return true
+	}, nil)
+
+	for _, a := range it.Comments.annotations("include") {
+		for _, ref := range a.Args {
+			obj, err := lookupObject(pkg, f, ref)
+			if err != nil {
+				return fmt.Errorf("%s: include %s: %v", it.Name, ref, err)
+			}
+			fns, err := setFuncs(all, obj, map[types.Object]struct{}{})
+			if err != nil {
+				return fmt.Errorf("%s: include %s: %v", it.Name, ref, err)
+			}
+			for _, fn := range fns {
+				if err := addSetProvider(it, ref, fn); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func addSetProvider(it *InterfaceType, set string, fn *types.Func) error {
+	if !fn.Exported() && fn.Pkg().Path() != it.PackagePath {
+		return fmt.Errorf("%s: %s of %s must be exported", it.Name, fn.FullName(), set)
+	}
+	sig := fn.Type().(*types.Signature)
+	if sig.Results().Len() == 0 {
+		return fmt.Errorf("%s: %s of %s returns nothing", it.Name, fn.FullName(), set)
+	}
+	ret := ParameterType{typ: sig.Results().At(0).Type()}
+	for _, m := range it.components() {
+		if !ret.Identical(m.constructedType()) {
+			continue
+		}
+		p, err := providerFunc(fn, m)
+		if err != nil {
+			return err
+		}
+		p.Set = set
+		it.SetProviders = append(it.SetProviders, p)
+	}
+	return nil
+}
+
+// setFuncs returns the functions listed by the set obj, following the
+// nested sets.
+func setFuncs(all map[string]*packages.Package, obj types.Object, seen map[types.Object]struct{}) ([]*types.Func, error) {
+	if _, ok := seen[obj]; ok {
+		return nil, nil
+	}
+	seen[obj] = struct{}{}
+
+	v, ok := obj.(*types.Var)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a variable annotated with %s", obj.Name(), setAnnotation)
+	}
+	pkg := all[v.Pkg().Path()]
+	if pkg == nil {
+		return nil, fmt.Errorf("package %s of %s is not loaded", v.Pkg().Path(), v.Name())
+	}
+	lit, err := setLiteral(pkg, v.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*types.Func
+	for _, elt := range lit.Elts {
+		var id *ast.Ident
+		switch e := elt.(type) {
+		case *ast.Ident:
+			id = e
+		case *ast.SelectorExpr:
+			id = e.Sel
+		default:
+			return nil, fmt.Errorf("%s: %s must be a function or a set", v.Name(), types.ExprString(elt))
+		}
+		switch o := pkg.TypesInfo.Uses[id].(type) {
+		case *types.Func:
+			if o.Type().(*types.Signature).Recv() != nil {
+				return nil, fmt.Errorf("%s: %s must be a function, not a method", v.Name(), types.ExprString(elt))
+			}
+			res = append(res, o)
+		case *types.Var:
+			fns, err := setFuncs(all, o, seen)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, fns...)
+		default:
+			return nil, fmt.Errorf("%s: %s must be a function or a set", v.Name(), types.ExprString(elt))
+		}
+	}
+	return res, nil
+}
+
+// setLiteral returns the composite literal initializing the set name.
+func setLiteral(pkg *packages.Package, name string) (*ast.CompositeLit, error) {
+	for _, f := range pkg.Syntax {
+		for _, decl := range f.Decls {
+			g, ok := decl.(*ast.GenDecl)
+			if !ok || g.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range g.Specs {
+				vs := spec.(*ast.ValueSpec)
+				for i, n := range vs.Names {
+					if n.Name != name {
+						continue
+					}
+					doc := vs.Doc
+					if doc == nil {
+						doc = g.Doc
+					}
+					if !isAnnotated(findComments(doc), setAnnotation) {
+						return nil, fmt.Errorf("%s is not annotated with %s", name, setAnnotation)
+					}
+					if i >= len(vs.Values) {
+						return nil, fmt.Errorf("%s must be initialized with a list of providers", name)
+					}
+					lit, ok := vs.Values[i].(*ast.CompositeLit)
+					if !ok {
+						return nil, fmt.Errorf("%s must be initialized with a list of providers", name)
+					}
+					return lit, nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("declaration of %s not found", name)
+}
@@ -3,10 +3,16 @@ package internal
 import (
 	"testing"
 
+	"go/ast"
 	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
 
 	"fmt"
 
+	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/imports"
 )
 
@@ -29,3 +35,55 @@ func fixImports(t *testing.T, src []byte) []byte {
 	}
 	return dist
 }
+
+// parseTestPackage type checks src as the package at path without touching
+// the file system. Imports are resolved from deps first, then from GOROOT.
+func parseTestPackage(t *testing.T, path, src string, deps ...*packages.Package) *packages.Package {
+	t.Helper()
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path+"/tmp.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{
+		Types: map[ast.Expr]types.TypeAndValue{},
+		Defs:  map[*ast.Ident]types.Object{},
+		Uses:  map[*ast.Ident]types.Object{},
+		// Implicits holds the names of the unnamed imports.
+		Implicits: map[ast.Node]types.Object{},
+	}
+	conf := &types.Config{
+		Importer: testImporter{deps: deps, fallback: importer.ForCompiler(fset, "source", nil)},
+		Error:    func(error) {},
+	}
+	tpkg, _ := conf.Check(path, fset, []*ast.File{f}, info)
+
+	imports := map[string]*packages.Package{}
+	for _, d := range deps {
+		imports[d.PkgPath] = d
+	}
+	return &packages.Package{
+		Imports:   imports,
+		ID:        path,
+		Name:      f.Name.Name,
+		PkgPath:   path,
+		Fset:      fset,
+		Syntax:    []*ast.File{f},
+		Types:     tpkg,
+		TypesInfo: info,
+	}
+}
+
+type testImporter struct {
+	deps     []*packages.Package
+	fallback types.Importer
+}
+
+func (i testImporter) Import(path string) (*types.Package, error) {
+	for _, d := range i.deps {
+		if d.PkgPath == path {
+			return d.Types, nil
+		}
+	}
+	return i.fallback.Import(path)
+}
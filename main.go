@@ -1,9 +1,9 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -11,6 +11,7 @@ import (
 
 	"github.com/akito0107/dicon/internal"
 	"github.com/urfave/cli"
+	"golang.org/x/tools/go/packages"
 )
 
 var (
@@ -41,7 +42,7 @@ func main() {
 				return runGenerate(pkgs, filename, d)
 			},
 			Flags: []cli.Flag{
-				cli.StringFlag{Name: "pkg, p", Value: "", Usage: "target package(s)."},
+				cli.StringFlag{Name: "pkg, p", Value: "", Usage: "target package(s): directories, import paths or patterns such as ./..."},
 				cli.StringFlag{Name: "out, o", Value: "dicon_gen", Usage: "output file name"},
 				cli.BoolFlag{Name: "dry-run"},
 			},
@@ -58,7 +59,7 @@ func main() {
 				return runGenerateMock(distPackage, pkgs, filename, d)
 			},
 			Flags: []cli.Flag{
-				cli.StringFlag{Name: "pkg, p", Value: "", Usage: "target package(s)."},
+				cli.StringFlag{Name: "pkg, p", Value: "", Usage: "target package(s): directories, import paths or patterns such as ./..."},
 				cli.StringFlag{Name: "out, o", Value: "dicon_mock", Usage: "output file name"},
 				cli.StringFlag{Name: "dist, d", Value: "mock", Usage: "output package name"},
 				cli.BoolFlag{Name: "dry-run"},
@@ -72,86 +73,79 @@ func main() {
 }
 
 func runGenerate(pkgs []string, filename string, dry bool) error {
-	it, err := findDicon(pkgs)
+	loaded, err := loadPackages(pkgs)
 	if err != nil {
 		return err
 	}
-	if it == nil {
+	its, err := findDicons(loaded)
+	if err != nil {
+		return err
+	}
+	if len(its) == 0 {
 		return fmt.Errorf("+DICON not found")
 	}
-	targetPkg := it.PackageName
-	funcnames := make([]string, 0, len(it.Funcs))
-	for _, fn := range it.Funcs {
-		funcnames = append(funcnames, fn.Name)
+	if err := internal.CheckDecorators(loaded, its); err != nil {
+		return err
 	}
 
-	var funcs []internal.FuncType
-	for _, pkg := range pkgs {
-		pkgDir := filepath.Join(".", filepath.FromSlash(pkg))
-		files, err := ioutil.ReadDir(pkgDir)
-		if err != nil {
-			return err
+	written := map[string]string{}
+	options := map[string]string{}
+	for i := range its {
+		it := &its[i]
+		name := filename
+		if it.Out != "" {
+			name = it.Out
+		}
+		path := filepath.Join(it.PackageDir, name)
+		if other, ok := written[path]; ok {
+			return fmt.Errorf("containers %s and %s are both written to %s.go: set +DICON out=<file> to split them", other, it.Name, path)
 		}
-		filenames := make([]string, 0, len(files))
-		for _, f := range files {
-			if strings.HasSuffix(f.Name(), ".go") {
-				filenames = append(filenames, filepath.Join(pkgDir, f.Name()))
+		written[path] = it.Name
+		for _, fn := range it.OptionFuncs() {
+			key := it.PackagePath + "." + fn
+			if other, ok := options[key]; ok {
+				return fmt.Errorf("containers %s and %s both generate %s: set +DICON option=<prefix> to rename the options of one of them", other, it.Name, fn)
 			}
+			options[key] = it.Name
 		}
 
-		_, pkgName := filepath.Split(pkgDir)
-		pparser := internal.NewPackageParser(pkgName)
-		ft, err := pparser.FindConstructors(filenames, funcnames)
-		if err != nil {
+		if err := generateContainer(loaded, it, name, dry); err != nil {
 			return err
 		}
-		funcs = append(funcs, ft...)
-	}
-
-	if err := internal.DetectCyclicDependency(funcs); err != nil {
-		return err
 	}
+	return nil
+}
 
-	g := internal.NewGenerator()
-
-	if err := g.Generate(it, funcs); err != nil {
+func generateContainer(loaded []*packages.Package, it *internal.InterfaceType, filename string, dry bool) error {
+	g, err := internal.GenerateContainer(loaded, it)
+	if err != nil {
 		return err
 	}
-
-	return writeFile(g, targetPkg, filename, dry)
+	return writeFile(g, it.PackageDir, filename, dry)
 }
 
 func runGenerateMock(distPackage string, pkgs []string, filename string, dry bool) error {
-	it, err := findDicon(pkgs)
+	loaded, err := loadPackages(pkgs)
 	if err != nil {
 		return err
 	}
-	if it == nil {
+	its, err := findDicons(loaded)
+	if err != nil {
+		return err
+	}
+	if len(its) == 0 {
 		return fmt.Errorf("+DICON not found")
 	}
 
-	funcnames := make([]string, 0, len(it.Funcs))
-	for _, fn := range it.Funcs {
-		funcnames = append(funcnames, fn.Name)
+	var typeNames []string
+	for i := range its {
+		typeNames = append(typeNames, its[i].ComponentTypeNames()...)
 	}
 
 	var mockTargets []internal.InterfaceType
-	for _, pkg := range pkgs {
-		pkgDir := filepath.Join(".", filepath.FromSlash(pkg))
-		files, err := ioutil.ReadDir(pkgDir)
-		if err != nil {
-			return err
-		}
-		filenames := make([]string, 0, len(files))
-		for _, f := range files {
-			if strings.HasSuffix(f.Name(), ".go") {
-				filenames = append(filenames, filepath.Join(pkgDir, f.Name()))
-			}
-		}
-
-		_, pkgName := filepath.Split(pkgDir)
-		pparser := internal.NewPackageParser(pkgName)
-		m, err := pparser.FindDependencyInterfaces(filenames, funcnames)
+	for _, pkg := range loaded {
+		pparser := internal.NewPackageParser(pkg)
+		m, err := pparser.FindDependencyInterfaces(typeNames)
 		if err != nil {
 			return err
 		}
@@ -160,61 +154,63 @@ func runGenerateMock(distPackage string, pkgs []string, filename string, dry boo
 
 	g := internal.NewGenerator()
 	g.PackageName = distPackage
-	if err := g.GenerateMock(it, mockTargets); err != nil {
+	if err := g.GenerateMock(&its[0], mockTargets); err != nil {
 		return err
 	}
 	return writeFile(g, distPackage, filename, dry)
 }
 
-func findDicon(pkgs []string) (*internal.InterfaceType, error) {
-	var it *internal.InterfaceType
-	for _, pkg := range pkgs {
-		pkgDir := filepath.Join(".", filepath.FromSlash(pkg))
+func loadPackages(pkgs []string) ([]*packages.Package, error) {
+	return internal.LoadPackages(packagePatterns(pkgs)...)
+}
 
-		files, err := ioutil.ReadDir(pkgDir)
-		if err != nil {
-			return nil, err
+// packagePatterns turns --pkg values into go/packages patterns. Import paths
+// and patterns such as ./... are passed through, while a bare directory name
+// is kept meaning the directory relative to the working directory.
+func packagePatterns(pkgs []string) []string {
+	patterns := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		pkg = strings.TrimSpace(pkg)
+		if pkg == "" {
+			continue
 		}
-		filenames := make([]string, 0, len(files))
-		for _, f := range files {
-			if strings.HasSuffix(f.Name(), ".go") {
-				filenames = append(filenames, filepath.Join(pkgDir, f.Name()))
+		if !strings.HasPrefix(pkg, ".") && !filepath.IsAbs(pkg) {
+			if fi, err := os.Stat(filepath.FromSlash(pkg)); err == nil && fi.IsDir() {
+				pkg = "./" + pkg
 			}
 		}
+		patterns = append(patterns, pkg)
+	}
+	if len(patterns) == 0 {
+		patterns = append(patterns, ".")
+	}
+	return patterns
+}
 
-		_, pkgName := filepath.Split(pkgDir)
-		pparser := internal.NewPackageParser(pkgName)
-		res, err := pparser.FindDicon(filenames)
+func findDicons(pkgs []*packages.Package) ([]internal.InterfaceType, error) {
+	var its []internal.InterfaceType
+	for _, pkg := range pkgs {
+		pparser := internal.NewPackageParser(pkg)
+		res, err := pparser.FindDicons()
 		if err != nil {
 			return nil, err
 		}
-
-		if res != nil {
-			it = res
-			break
-		}
+		its = append(its, res...)
 	}
-	return it, nil
+	return its, nil
 }
 
-func writeFile(g *internal.Generator, targetPkg string, filename string, dry bool) error {
-	name := filepath.Join(targetPkg, filename+".go")
-	var w io.Writer
-	if dry {
-		w = os.Stdout
-	} else {
-		if _, err := os.Stat(name); !os.IsNotExist(err) {
-			os.Remove(name)
-		}
-		out, err := os.Create(name)
-		if err != nil {
-			return err
-		}
-		defer out.Close()
-		w = out
+// writeFile formats the generated source before touching the file, so that
+// a generation error leaves the previous file in place.
+func writeFile(g *internal.Generator, dir string, filename string, dry bool) error {
+	name := filepath.Join(dir, filename+".go")
+	var buf bytes.Buffer
+	if err := g.Out(&buf, name); err != nil {
+		return err
 	}
-	if err := g.Out(w, name); err != nil {
+	if dry {
+		_, err := io.Copy(os.Stdout, &buf)
 		return err
 	}
-	return nil
+	return os.WriteFile(name, buf.Bytes(), 0666)
 }
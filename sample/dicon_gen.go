@@ -0,0 +1,138 @@
+// Code generated by "dicon"; DO NOT EDIT.
+
+package sample
+
+import (
+	"sync"
+
+	"github.com/akito0107/dicon/sample2"
+	"github.com/pkg/errors"
+)
+
+type dicontainer struct {
+	components struct {
+		sampleComponent struct {
+			sync.Mutex
+			done     bool
+			instance SampleComponent
+		}
+		otherComponent struct {
+			sync.Mutex
+			done     bool
+			instance OtherComponent
+		}
+		moreComponent struct {
+			sync.Mutex
+			done     bool
+			instance MoreComponent
+		}
+		sample2Component struct {
+			sync.Mutex
+			done     bool
+			instance sample2.Sample2Component
+		}
+	}
+}
+
+type DIContainerOption func(*dicontainer)
+
+func WithSampleComponent(instance SampleComponent) DIContainerOption {
+	return func(d *dicontainer) {
+		d.components.sampleComponent.instance, d.components.sampleComponent.done = instance, true
+	}
+}
+
+func WithOtherComponent(instance OtherComponent) DIContainerOption {
+	return func(d *dicontainer) {
+		d.components.otherComponent.instance, d.components.otherComponent.done = instance, true
+	}
+}
+
+func WithMoreComponent(instance MoreComponent) DIContainerOption {
+	return func(d *dicontainer) {
+		d.components.moreComponent.instance, d.components.moreComponent.done = instance, true
+	}
+}
+
+func WithSample2Component(instance sample2.Sample2Component) DIContainerOption {
+	return func(d *dicontainer) {
+		d.components.sample2Component.instance, d.components.sample2Component.done = instance, true
+	}
+}
+
+func NewDIContainer(opts ...DIContainerOption) DIContainer {
+	d := &dicontainer{}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+func (d *dicontainer) SampleComponent() (SampleComponent, error) {
+	c := &d.components.sampleComponent
+	c.Lock()
+	defer c.Unlock()
+	if c.done {
+		return c.instance, nil
+	}
+	instance, err := NewSampleComponent()
+	if err != nil {
+		return nil, errors.Wrap(err, "creation SampleComponent failed at DICON")
+	}
+	c.instance, c.done = instance, true
+	return instance, nil
+}
+func (d *dicontainer) OtherComponent() (OtherComponent, error) {
+	c := &d.components.otherComponent
+	c.Lock()
+	defer c.Unlock()
+	if c.done {
+		return c.instance, nil
+	}
+	dep0, err := d.SampleComponent()
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve SampleComponent failed at DICON")
+	}
+	instance, err := NewOtherComponent(dep0)
+	if err != nil {
+		return nil, errors.Wrap(err, "creation OtherComponent failed at DICON")
+	}
+	c.instance, c.done = instance, true
+	return instance, nil
+}
+func (d *dicontainer) MoreComponent() (MoreComponent, error) {
+	c := &d.components.moreComponent
+	c.Lock()
+	defer c.Unlock()
+	if c.done {
+		return c.instance, nil
+	}
+	dep0, err := d.SampleComponent()
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve SampleComponent failed at DICON")
+	}
+	dep1, err := d.OtherComponent()
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve OtherComponent failed at DICON")
+	}
+	instance, err := NewMoreComponent(dep0, dep1)
+	if err != nil {
+		return nil, errors.Wrap(err, "creation MoreComponent failed at DICON")
+	}
+	c.instance, c.done = instance, true
+	return instance, nil
+}
+func (d *dicontainer) Sample2Component() (sample2.Sample2Component, error) {
+	c := &d.components.sample2Component
+	c.Lock()
+	defer c.Unlock()
+	if c.done {
+		return c.instance, nil
+	}
+	instance, err := sample2.NewSample2Component()
+	if err != nil {
+		return nil, errors.Wrap(err, "creation Sample2Component failed at DICON")
+	}
+	c.instance, c.done = instance, true
+	return instance, nil
+}
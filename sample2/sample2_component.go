@@ -0,0 +1,16 @@
+package sample2
+
+type Sample2Component interface {
+	Exec() error
+}
+
+type sample2Component struct {
+}
+
+func NewSample2Component() (Sample2Component, error) {
+	return &sample2Component{}, nil
+}
+
+func (s *sample2Component) Exec() error {
+	return nil
+}